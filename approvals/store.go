@@ -0,0 +1,239 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package approvals stores the incidents waiting for a human to approve their remediation.
+package approvals
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// Status of a Request.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+	StatusExpired  = "expired"
+)
+
+// Errors returned by the Store.
+var (
+	ErrNotFound = errors.New("no such approval request")
+	ErrDecided  = errors.New("approval request already decided")
+	ErrExpired  = errors.New("approval request expired")
+)
+
+// Request is an incident whose pre-audits passed, waiting to be approved before
+// its remediations run.
+type Request struct {
+	// ID is the ID of the incident.
+	ID       string
+	Incident lib.Incident
+	// Device the incident acts on, for display.
+	Device    string
+	Requested time.Time
+	Expires   time.Time
+	Status    string
+	DecidedBy string    `json:",omitempty"`
+	DecidedAt time.Time `json:",omitempty"`
+	// Reason given with the decision.
+	Reason string `json:",omitempty"`
+	// PreAudits holds the output of each pre-audit that passed, by step name.
+	PreAudits map[string]json.RawMessage `json:",omitempty"`
+}
+
+// Store keeps each request as a JSON file of a directory, so that the executors
+// and the goar command can share it.
+type Store struct {
+	dir string
+}
+
+// Suffixes of the request files: requests are claimed by renaming their file,
+// so that a request is decided once and a decided request is handled by a single executor.
+const (
+	requestSuffix  = ".json"
+	claimedSuffix  = ".claimed"
+	decidingSuffix = ".deciding"
+)
+
+// NewStore returns the store of the requests in dir, creating it if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Add stores a new request.
+func (store *Store) Add(request *Request) error {
+	return store.write(request)
+}
+
+// Get returns the request of an incident.
+func (store *Store) Get(id string) (*Request, error) {
+	return store.read(store.path(id, requestSuffix))
+}
+
+// List returns all the requests not claimed yet, oldest first.
+func (store *Store) List() ([]*Request, error) {
+	paths, err := filepath.Glob(filepath.Join(store.dir, "*"+requestSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]*Request, 0, len(paths))
+	for _, path := range paths {
+		request, err := store.read(path)
+		if err == ErrNotFound {
+			// claimed meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Requested.Before(requests[j].Requested)
+	})
+	return requests, nil
+}
+
+// Decide approves or denies a pending request. The request is claimed while being
+// decided, like in Claim, so that concurrent decisions get ErrDecided and an executor
+// cannot handle it as expired meanwhile.
+func (store *Store) Decide(id string, approve bool, by string, reason string) (*Request, error) {
+	pending, deciding := store.path(id, requestSuffix), store.path(id, decidingSuffix)
+	if err := os.Rename(pending, deciding); err != nil {
+		if os.IsNotExist(err) {
+			return store.taken(id)
+		}
+		return nil, err
+	}
+
+	request, err := store.read(deciding)
+	if err == nil && request.Status != StatusPending {
+		err = ErrDecided
+	} else if err == nil && time.Now().After(request.Expires) {
+		err = ErrExpired
+	}
+	if err == nil {
+		request.Status = StatusDenied
+		if approve {
+			request.Status = StatusApproved
+		}
+		request.DecidedBy = by
+		request.DecidedAt = time.Now()
+		request.Reason = reason
+		// the decision replaces the pending request in a single rename
+		if err = store.writeFile(deciding, request); err == nil {
+			return request, os.Rename(deciding, pending)
+		}
+	}
+
+	// left undecided
+	if renameErr := os.Rename(deciding, pending); renameErr != nil {
+		return request, renameErr
+	}
+	return request, err
+}
+
+// taken returns the error of a decision on a request that is not in the store: ErrDecided
+// if it is being decided, or handled once decided, ErrExpired if it is being handled as
+// expired, ErrNotFound otherwise.
+func (store *Store) taken(id string) (*Request, error) {
+	if request, err := store.read(store.path(id, claimedSuffix)); err == nil {
+		if request.Status == StatusPending {
+			return request, ErrExpired
+		}
+		return request, ErrDecided
+	}
+	if _, err := os.Stat(store.path(id, decidingSuffix)); err == nil {
+		return nil, ErrDecided
+	}
+	return nil, ErrNotFound
+}
+
+// Claim takes a request out of the store for the caller to act on it, ErrNotFound
+// if someone else claimed it first. Done must be called once the request is handled.
+func (store *Store) Claim(id string) (*Request, error) {
+	claimed := store.path(id, claimedSuffix)
+	if err := os.Rename(store.path(id, requestSuffix), claimed); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return store.read(claimed)
+}
+
+// Unclaim puts back a claimed request, for it to be handled again later.
+func (store *Store) Unclaim(id string) error {
+	return os.Rename(store.path(id, claimedSuffix), store.path(id, requestSuffix))
+}
+
+// Done removes a claimed request.
+func (store *Store) Done(id string) error {
+	return os.Remove(store.path(id, claimedSuffix))
+}
+
+// path returns the path of the file of a request. IDs are random hex strings,
+// path separators are replaced anyway to stay within the directory.
+func (store *Store) path(id string, suffix string) string {
+	return filepath.Join(store.dir, strings.Replace(filepath.Base(id), ".", "_", -1)+suffix)
+}
+
+func (store *Store) read(path string) (*Request, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var request Request
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// write replaces the file of a request atomically.
+func (store *Store) write(request *Request) error {
+	return store.writeFile(store.path(request.ID, requestSuffix), request)
+}
+
+// writeFile replaces the file at path with request atomically.
+func (store *Store) writeFile(path string, request *Request) error {
+	content, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(store.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
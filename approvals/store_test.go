@@ -0,0 +1,225 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package approvals
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a store in a temporary directory holding a pending request
+// of incident "test", expiring at expires.
+func newTestStore(t *testing.T, expires time.Time) *Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &Request{ID: "test", Requested: time.Now(), Expires: expires, Status: StatusPending}
+	if err := store.Add(request); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestDecide(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare puts the request of the store in the state of the test
+		prepare func(t *testing.T, store *Store)
+		id      string
+		expires time.Duration
+		status  string
+		err     error
+	}{
+		{
+			name:    "approved",
+			id:      "test",
+			expires: time.Hour,
+			status:  StatusApproved,
+		},
+		{
+			name:    "already decided",
+			prepare: func(t *testing.T, store *Store) { mustDecide(t, store, false) },
+			id:      "test",
+			expires: time.Hour,
+			status:  StatusDenied,
+			err:     ErrDecided,
+		},
+		{
+			name:    "expired",
+			id:      "test",
+			expires: -time.Second,
+			status:  StatusPending,
+			err:     ErrExpired,
+		},
+		{
+			name:    "unknown",
+			id:      "other",
+			expires: time.Hour,
+			status:  StatusPending,
+			err:     ErrNotFound,
+		},
+		{
+			name:    "claimed once decided",
+			prepare: func(t *testing.T, store *Store) { mustDecide(t, store, true); mustClaim(t, store) },
+			id:      "test",
+			expires: time.Hour,
+			err:     ErrDecided,
+		},
+		{
+			name:    "claimed as expired",
+			prepare: func(t *testing.T, store *Store) { mustClaim(t, store) },
+			id:      "test",
+			expires: time.Hour,
+			err:     ErrExpired,
+		},
+		{
+			name: "being decided",
+			prepare: func(t *testing.T, store *Store) {
+				if err := os.Rename(store.path("test", requestSuffix), store.path("test", decidingSuffix)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			id:      "test",
+			expires: time.Hour,
+			err:     ErrDecided,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t, time.Now().Add(test.expires))
+			if test.prepare != nil {
+				test.prepare(t, store)
+			}
+
+			_, err := store.Decide(test.id, true, "alice", "looks fine")
+			if err != test.err {
+				t.Fatalf("Decide error = %v, want %v", err, test.err)
+			}
+			if test.status == "" {
+				return
+			}
+			request, err := store.Get("test")
+			if err != nil {
+				t.Fatalf("Get: %s", err)
+			}
+			if request.Status != test.status {
+				t.Errorf("Status = %s, want %s", request.Status, test.status)
+			}
+			if _, err := os.Stat(store.path("test", decidingSuffix)); !os.IsNotExist(err) {
+				t.Errorf("request left being decided")
+			}
+		})
+	}
+}
+
+func TestDecideFiles(t *testing.T) {
+	store := newTestStore(t, time.Now().Add(time.Hour))
+	if _, err := store.Decide("test", false, "alice", "change freeze"); err != nil {
+		t.Fatalf("Decide: %s", err)
+	}
+
+	// the decided request is renamed into place, nothing is left behind
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	if len(names) != 1 || names[0] != "test"+requestSuffix {
+		t.Errorf("store files %v, want only the request", names)
+	}
+
+	request, err := store.Get("test")
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if request.Status != StatusDenied || request.DecidedBy != "alice" || request.Reason != "change freeze" || request.DecidedAt.IsZero() {
+		t.Errorf("decided request %+v, want denied by alice for change freeze", request)
+	}
+}
+
+func TestDecideConcurrently(t *testing.T) {
+	store := newTestStore(t, time.Now().Add(time.Hour))
+
+	const deciders = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, deciders)
+	for i := 0; i < deciders; i++ {
+		wg.Add(1)
+		go func(approve bool) {
+			defer wg.Done()
+			_, err := store.Decide("test", approve, "alice", "")
+			errs <- err
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	close(errs)
+
+	decided := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			decided++
+		case ErrDecided:
+		default:
+			t.Errorf("Decide error: %s", err)
+		}
+	}
+	if decided != 1 {
+		t.Errorf("decided %d times, want once", decided)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	store := newTestStore(t, time.Now().Add(time.Hour))
+
+	mustClaim(t, store)
+	if _, err := store.Claim("test"); err != ErrNotFound {
+		t.Fatalf("second Claim error = %v, want ErrNotFound", err)
+	}
+	if requests, err := store.List(); err != nil || len(requests) != 0 {
+		t.Fatalf("List = %d request(s), %v, want none once claimed", len(requests), err)
+	}
+
+	if err := store.Unclaim("test"); err != nil {
+		t.Fatalf("Unclaim: %s", err)
+	}
+	if requests, err := store.List(); err != nil || len(requests) != 1 {
+		t.Fatalf("List = %d request(s), %v, want the unclaimed one", len(requests), err)
+	}
+
+	mustClaim(t, store)
+	if err := store.Done("test"); err != nil {
+		t.Fatalf("Done: %s", err)
+	}
+	if _, err := store.Get("test"); err != ErrNotFound {
+		t.Errorf("Get error = %v once done, want ErrNotFound", err)
+	}
+}
+
+// mustDecide approves or denies the request of incident "test".
+func mustDecide(t *testing.T, store *Store, approve bool) {
+	t.Helper()
+	if _, err := store.Decide("test", approve, "bob", ""); err != nil {
+		t.Fatalf("Decide: %s", err)
+	}
+}
+
+// mustClaim claims the request of incident "test".
+func mustClaim(t *testing.T, store *Store) {
+	t.Helper()
+	if _, err := store.Claim("test"); err != nil {
+		t.Fatalf("Claim: %s", err)
+	}
+}
@@ -24,6 +24,15 @@ func GetConfig(path string) (Config, error) {
 	if err != nil {
 		return conf, err
 	}
+	if conf.QueueRateLimited == "" {
+		conf.QueueRateLimited = conf.QueueIncident + "_ratelimited"
+	}
+	if conf.QueueDeadLetter == "" {
+		conf.QueueDeadLetter = conf.QueueIncident + "_deadletter"
+	}
+	if conf.QueueQuarantine == "" {
+		conf.QueueQuarantine = conf.QueueIncident + "_quarantine"
+	}
 	return conf, nil
 }
 
@@ -41,3 +50,17 @@ func GetRules(path string) ([]Rule, error) {
 	}
 	return rules, nil
 }
+
+// GetTopology reads the yaml file describing the network topology
+func GetTopology(path string) (Topology, error) {
+	var topology Topology
+	yamlFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		return topology, err
+	}
+	err = yaml.Unmarshal(yamlFile, &topology)
+	if err != nil {
+		return topology, err
+	}
+	return topology, nil
+}
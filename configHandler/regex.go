@@ -0,0 +1,19 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package confighandler
+
+import "regexp"
+
+// pythonNamedGroup matches the opening of a Python/PCRE style named group: (?<name>
+var pythonNamedGroup = regexp.MustCompile(`\(\?<([A-Za-z_][A-Za-z0-9_]*)>`)
+
+// CompileRegex compiles a rule or header expression. Named groups may be written
+// either in Go syntax (?P<name>) or in Python/PCRE syntax (?<name>), the latter
+// being rewritten to the former before compiling.
+func CompileRegex(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile(pythonNamedGroup.ReplaceAllString(expr, `(?P<${1}>`))
+}
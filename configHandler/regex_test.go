@@ -0,0 +1,70 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package confighandler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileRegex(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		names []string
+		err   string
+	}{
+		{
+			name:  "python named groups",
+			expr:  `(?<time>\w{3}) (?<host_name2>\w+)`,
+			names: []string{"", "time", "host_name2"},
+		},
+		{
+			name:  "go named groups",
+			expr:  `(?P<time>\w{3}) (?P<hostname>\w+)`,
+			names: []string{"", "time", "hostname"},
+		},
+		{
+			name:  "mixed and unnamed groups",
+			expr:  `(?<time>\w{3}) (\d+) (?:x|y) (?P<hostname>\w+)`,
+			names: []string{"", "time", "", "hostname"},
+		},
+		{
+			name:  "escaped parenthesis",
+			expr:  `\(?<not>a group\)`,
+			names: []string{""},
+		},
+		{
+			name: "unbalanced group",
+			expr: `(?<time>\w{3}`,
+			err:  "missing closing )",
+		},
+		{
+			name: "bad repetition",
+			expr: `(?<hostname>*\w+)`,
+			err:  "missing argument to repetition operator",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			regex, err := CompileRegex(test.expr)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("CompileRegex(%q) error = %v, want %q", test.expr, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompileRegex(%q): %s", test.expr, err)
+			}
+			if names := regex.SubexpNames(); !reflect.DeepEqual(names, test.names) {
+				t.Errorf("CompileRegex(%q) groups = %q, want %q", test.expr, names, test.names)
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@
 
 package confighandler
 
+import "time"
+
 // Rule represents a how a syslog matching rule should look like
 type Rule struct {
 	RuleName     string   `yaml:"RuleName"`
@@ -15,6 +17,137 @@ type Rule struct {
 	PreAudits    []string `yaml:"PreAudits"`
 	Remediations []string `yaml:"Remediations"`
 	PostAudits   []string `yaml:"PostAudits"`
+	// Rollbacks undo the Remediations with the same index, an empty string
+	// when a remediation has no rollback. See WorkflowStep.Rollback.
+	Rollbacks []string `yaml:"Rollbacks"`
+	// Workflow names a workflow of the workflows file to run instead of the
+	// PreAudits/Remediations/PostAudits phases. Its steps are copied into Steps
+	// when the rules are loaded, Steps can also be defined in the rule directly.
+	Workflow string         `yaml:"Workflow"`
+	Steps    []WorkflowStep `yaml:"Steps"`
+	// Suppression collapses repeated incidents of the rule, disabled by default.
+	Suppression Suppression `yaml:"Suppression"`
+	// RateLimit caps how many incidents of the rule are published.
+	RateLimit RateLimit `yaml:"RateLimit"`
+	// Mode is one of live (default), dryrun or audit-only. Both run the pre-audits and
+	// post-audits, dryrun only logging the remediations and rollbacks while audit-only
+	// leaves them out. Their incidents end simulated, or failed if an audit did not pass.
+	Mode string `yaml:"Mode"`
+	// RequiresApproval parks the incidents of the rule once their pre-audits passed,
+	// until someone approves or denies them, within ApprovalTimeout (APPROVAL_TIMEOUT
+	// from the config by default).
+	RequiresApproval bool          `yaml:"RequiresApproval"`
+	ApprovalTimeout  time.Duration `yaml:"ApprovalTimeout"`
+	// Timeout is how long each audit, remediation and rollback of the rule can run,
+	// EXECUTOR_TIMEOUT from the config by default. Workflow steps can set their own.
+	Timeout time.Duration `yaml:"Timeout"`
+	// LockKeys are the parameters identifying the device an incident acts on, the
+	// executor never runs two incidents with the same values concurrently.
+	// Defaults to [hostname].
+	LockKeys []string `yaml:"LockKeys"`
+	// Retry defines how the executor retries failed incidents of the rule,
+	// RETRY from the config when MaxAttempts is not set.
+	Retry RetryPolicy `yaml:"Retry"`
+	// Threshold requires the rule to match repeatedly before creating an incident.
+	Threshold Threshold `yaml:"Threshold"`
+	// HoldDown delays the incidents of the rule, dropping them if a line matching
+	// ClearRegex arrives meanwhile with the same values for the ClearKeys parameters.
+	// ClearKeys defaults to the named groups of ClearRegex.
+	HoldDown   time.Duration `yaml:"HoldDown"`
+	ClearRegex string        `yaml:"ClearRegex"`
+	ClearKeys  []string      `yaml:"ClearKeys"`
+	// Examples and NonExamples are fixtures checked by `goar test`,
+	// they are not shipped with the incidents.
+	Examples    []RuleExample `yaml:"Examples" json:"-"`
+	NonExamples []string      `yaml:"NonExamples" json:"-"`
+}
+
+// Suppression defines how repeated incidents of a rule are collapsed: incidents
+// with the same values for the Keys parameters within Window become a single one.
+type Suppression struct {
+	Window time.Duration `yaml:"Window"`
+	Keys   []string      `yaml:"Keys"`
+}
+
+// RateLimit defines a token bucket: Rate incidents are allowed every Per, with bursts
+// of up to Burst incidents (Rate by default). A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  int           `yaml:"Rate"`
+	Per   time.Duration `yaml:"Per"`
+	Burst int           `yaml:"Burst"`
+}
+
+// RetryPolicy defines how failed incidents are retried: an incident is executed up to
+// MaxAttempts times, waiting Backoff before the first retry and twice as long before
+// every following one, up to MaxBackoff (no limit if zero). Only failures of a step of
+// one of the Phases (every phase when empty) are retried.
+type RetryPolicy struct {
+	MaxAttempts int           `yaml:"MaxAttempts"`
+	Backoff     time.Duration `yaml:"Backoff"`
+	MaxBackoff  time.Duration `yaml:"MaxBackoff"`
+	Phases      []string      `yaml:"Phases"`
+}
+
+// BreakerPolicy defines when the executors stop running a rule or a script: its breaker
+// opens once FailureRatio of its last Window runs failed, and the incidents that would
+// run it are quarantined. After Cooldown one incident is let through as a probe, its
+// success closing the breaker, its failure opening it again.
+type BreakerPolicy struct {
+	Window       int           `yaml:"Window"`
+	FailureRatio float64       `yaml:"FailureRatio"`
+	Cooldown     time.Duration `yaml:"Cooldown"`
+	Disabled     bool          `yaml:"Disabled"`
+}
+
+// Threshold defines how many times (Count) a rule must match within Window, for the
+// same values of the GroupBy parameters, before an incident is created.
+type Threshold struct {
+	Count   int           `yaml:"Count"`
+	Window  time.Duration `yaml:"Window"`
+	GroupBy []string      `yaml:"GroupBy"`
+}
+
+// Workflow is a named set of steps, scheduled according to their dependencies.
+type Workflow struct {
+	Name  string         `yaml:"Name"`
+	Steps []WorkflowStep `yaml:"Steps"`
+}
+
+// WorkflowStep is a script run as part of a workflow. A step starts once all the
+// steps it DependsOn are done, steps without pending dependencies run in parallel.
+type WorkflowStep struct {
+	Name string `yaml:"Name"`
+	Cmd  string `yaml:"Cmd"`
+	// Phase is one of preaudit, remediation (default) or postaudit.
+	Phase     string   `yaml:"Phase"`
+	DependsOn []string `yaml:"DependsOn"`
+	// When makes the step conditional on the output of a step it depends on.
+	When *StepCondition `yaml:"When"`
+	// Timeout of the step, the one of the rule by default.
+	Timeout time.Duration `yaml:"Timeout"`
+	// Rollback is the script undoing a remediation step. When a later step fails,
+	// the rollbacks of the remediation steps that succeeded run in reverse order.
+	Rollback string `yaml:"Rollback"`
+}
+
+// StepCondition compares a value from the output of a previous step. Key is result or
+// data.<name>, for a key of the returned data: a step only starts once its dependencies
+// passed, so passed and success are not allowed. The step runs only if the value,
+// formatted as a string, equals Equals ("true" by default). Otherwise it is skipped,
+// which still satisfies the steps depending on it.
+type StepCondition struct {
+	Step   string `yaml:"Step"`
+	Key    string `yaml:"Key"`
+	Equals string `yaml:"Equals"`
+}
+
+// RuleExample is a sample log line together with the outcome expected from the rules
+type RuleExample struct {
+	Line string `yaml:"Line"`
+	// Rule expected to match Line, the rule defining the example if empty.
+	Rule string `yaml:"Rule"`
+	// Parameters expected in the incident. Only the listed ones are compared.
+	Parameters map[string]string `yaml:"Parameters"`
 }
 
 // Config is a struc of configs :D
@@ -32,4 +165,76 @@ type Config struct {
 	RulesFile     string `yaml:"RULESFILE"`
 	SyslogIP      string `yaml:"SYSLOG_LISTENIP"`
 	SyslogPort    string `yaml:"SYSLOG_LISTENPORT"`
+	TopologyFile  string `yaml:"TOPOLOGYFILE"`
+	WorkflowsFile string `yaml:"WORKFLOWSFILE"`
+	// Queue receiving the incidents over a rate limit, for human review.
+	// Defaults to QUEUE_INCIDENT with a "_ratelimited" suffix.
+	QueueRateLimited string `yaml:"QUEUE_RATELIMITED"`
+	// Queue receiving the incidents the executor gave up on, with the failure reason.
+	// Defaults to QUEUE_INCIDENT with a "_deadletter" suffix.
+	QueueDeadLetter string `yaml:"QUEUE_DEADLETTER"`
+	// Topic exchange the executors publish the incident events to (see lib.Event),
+	// with <event type>.<rule name> as routing key. No events are published if empty.
+	ResultsExchange string `yaml:"RESULTS_EXCHANGE"`
+	// HistoryDir holds the history of what the executors did, shared by the executors
+	// and the goar command. Nothing is recorded if empty.
+	HistoryDir string `yaml:"HISTORY_DIR"`
+	// Retry policy of the rules not defining one.
+	Retry RetryPolicy `yaml:"RETRY"`
+	// Breaker policy of the rules and scripts.
+	Breaker BreakerPolicy `yaml:"BREAKER"`
+	// Queue receiving the incidents of an open breaker, for review.
+	// Defaults to QUEUE_INCIDENT with a "_quarantine" suffix.
+	QueueQuarantine string `yaml:"QUEUE_QUARANTINE"`
+	// Number of incidents an executor processes concurrently.
+	ExecutorWorkers int `yaml:"EXECUTOR_WORKERS"`
+	// ExecutorTimeout is how long a script can run when neither its rule nor its step set a Timeout.
+	ExecutorTimeout time.Duration `yaml:"EXECUTOR_TIMEOUT"`
+	// DeviceLocker is where executors take the lease of a device before acting on it:
+	// rabbitmq, shared by every executor, or memory (default) for a single executor.
+	DeviceLocker string `yaml:"DEVICE_LOCKER"`
+	// LockTTL is how long a lease lasts without being renewed, 1m by default.
+	LockTTL time.Duration `yaml:"LOCK_TTL"`
+	// ApprovalsDir holds the incidents waiting for approval, shared by the executors
+	// and the goar command.
+	ApprovalsDir string `yaml:"APPROVALS_DIR"`
+	// ApprovalTimeout is how long incidents wait for approval, 1h by default.
+	ApprovalTimeout time.Duration `yaml:"APPROVAL_TIMEOUT"`
+	// ApprovalNotify is an optional script of the remediations directory run when an
+	// approval is requested, approved, denied or expires.
+	ApprovalNotify string `yaml:"APPROVAL_NOTIFY"`
+	// LockRetryDelay is how long incidents of a device locked elsewhere are delayed, 30s by default.
+	LockRetryDelay time.Duration `yaml:"LOCK_RETRY_DELAY"`
+	// Rate limits applied to all the incidents and per DeviceType of their rule.
+	GlobalRateLimit      RateLimit            `yaml:"RATELIMIT_GLOBAL"`
+	DeviceTypeRateLimits map[string]RateLimit `yaml:"RATELIMIT_DEVICETYPE"`
+	// BaseReg is the "header" regex common to every monitored device.
+	// Its named captures are added to the parameters of every incident.
+	BaseReg string `yaml:"BASEREG"`
+}
+
+// Topology describes how devices are connected. The processor uses it to merge
+// the incidents of both ends of a link and to suppress incidents of devices
+// whose upstream device is already in an active incident.
+type Topology struct {
+	// Window during which incidents of both ends of a link are merged.
+	Window time.Duration `yaml:"Window"`
+	// ActiveFor is how long a device is considered in an active incident
+	// after one of its incidents was published.
+	ActiveFor time.Duration `yaml:"ActiveFor"`
+	Links     []Link        `yaml:"Links"`
+	// Upstreams lists, for each device, the devices it depends on.
+	Upstreams map[string][]string `yaml:"Upstreams"`
+}
+
+// Link connects an interface of a device to an interface of its peer device.
+type Link struct {
+	A LinkEnd `yaml:"A"`
+	B LinkEnd `yaml:"B"`
+}
+
+// LinkEnd is one of the ends of a Link.
+type LinkEnd struct {
+	Device    string `yaml:"Device"`
+	Interface string `yaml:"Interface"`
 }
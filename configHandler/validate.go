@@ -0,0 +1,315 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package confighandler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// KnownDeviceTypes lists the values accepted in the DeviceType of a rule.
+var KnownDeviceTypes = map[string]bool{
+	"ARISTA": true,
+	"JUNOS":  true,
+	"OSX":    true,
+}
+
+// RequiredConfigKeys lists the config.yaml keys every service needs to be set.
+var RequiredConfigKeys = []string{
+	"QUEUE_LOG",
+	"QUEUE_INCIDENT",
+	"RABBITMQ_HOST",
+	"RABBITMQ_PORT",
+	"RABBITMQ_USER",
+	"RABBITMQ_PASS",
+	"RULESFILE",
+}
+
+// yamlErrorLine extracts the line number yaml reports in its parsing errors.
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// ValidationError describes a problem found in a config or rules file.
+// Line is 1-based, 0 when the problem cannot be tied to a line.
+type ValidationError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e ValidationError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// ValidateConfig checks that the config file parses, every required key is set
+// and BASEREG, if any, compiles.
+func ValidateConfig(path string) []ValidationError {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []ValidationError{{File: path, Msg: err.Error()}}
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return []ValidationError{parseError(path, err)}
+	}
+	lines := strings.Split(string(content), "\n")
+
+	var errs []ValidationError
+	for _, key := range RequiredConfigKeys {
+		value, ok := values[key]
+		if !ok {
+			errs = append(errs, ValidationError{File: path, Msg: fmt.Sprintf("missing required key %s", key)})
+		} else if value == nil || fmt.Sprint(value) == "" {
+			errs = append(errs, ValidationError{File: path, Line: findKey(lines, 0, len(lines), key), Msg: fmt.Sprintf("required key %s is empty", key)})
+		}
+	}
+
+	if baseReg, ok := values["BASEREG"].(string); ok && baseReg != "" {
+		if _, err := CompileRegex(baseReg); err != nil {
+			errs = append(errs, ValidationError{File: path, Line: findKey(lines, 0, len(lines), "BASEREG"), Msg: fmt.Sprintf("BASEREG does not compile: %s", err)})
+		}
+	}
+	return errs
+}
+
+// ValidateRules checks the rules file: every regex compiles, rule names are unique,
+// DeviceType is known, workflows are valid and every PreAudits/Remediations/PostAudits/Rollbacks
+// or workflow step script exists and is executable in remediationsPath.
+// The workflows file is optional, workflowsPath being empty if there is none.
+func ValidateRules(path string, workflowsPath string, remediationsPath string) []ValidationError {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []ValidationError{{File: path, Msg: err.Error()}}
+	}
+
+	workflows, errs := validateWorkflows(workflowsPath, remediationsPath)
+
+	var rules []Rule
+	if err := yaml.Unmarshal(content, &rules); err != nil {
+		return []ValidationError{parseError(path, err)}
+	}
+	lines := strings.Split(string(content), "\n")
+	starts := ruleStartLines(lines, len(rules))
+
+	names := make(map[string]int)
+	for i, rule := range rules {
+		from, to := starts[i], len(lines)
+		if i+1 < len(starts) {
+			to = starts[i+1]
+		}
+		report := func(key string, format string, args ...interface{}) {
+			errs = append(errs, ValidationError{File: path, Line: findKey(lines, from, to, key), Msg: fmt.Sprintf(format, args...)})
+		}
+
+		if rule.RuleName == "" {
+			report("RuleName", "rule #%d has no RuleName", i+1)
+		} else if line, ok := names[rule.RuleName]; ok {
+			report("RuleName", "rule name %s already used on line %d", rule.RuleName, line)
+		} else {
+			names[rule.RuleName] = findKey(lines, from, to, "RuleName")
+		}
+
+		if rule.Regex == "" {
+			report("Regex", "rule %s has no Regex", rule.RuleName)
+		} else if _, err := CompileRegex(rule.Regex); err != nil {
+			report("Regex", "rule %s regex does not compile: %s", rule.RuleName, err)
+		}
+
+		if rule.ClearRegex != "" {
+			if _, err := CompileRegex(rule.ClearRegex); err != nil {
+				report("ClearRegex", "rule %s clear regex does not compile: %s", rule.RuleName, err)
+			}
+			if rule.HoldDown <= 0 {
+				report("ClearRegex", "rule %s has a ClearRegex but no HoldDown", rule.RuleName)
+			}
+		}
+
+		if !KnownDeviceTypes[rule.DeviceType] {
+			report("DeviceType", "rule %s has unknown DeviceType %q", rule.RuleName, rule.DeviceType)
+		}
+
+		switch rule.Mode {
+		case "", ModeLive, ModeDryRun, ModeAuditOnly:
+		default:
+			report("Mode", "rule %s has unknown Mode %s", rule.RuleName, rule.Mode)
+		}
+
+		for _, phase := range rule.Retry.Phases {
+			switch phase {
+			case PhasePreAudit, PhaseRemediation, PhasePostAudit:
+			default:
+				report("Phases", "rule %s retries unknown phase %s", rule.RuleName, phase)
+			}
+		}
+
+		if len(rule.Rollbacks) > len(rule.Remediations) {
+			report("Rollbacks", "rule %s has more Rollbacks than Remediations", rule.RuleName)
+		}
+		for _, scripts := range [][]string{rule.PreAudits, rule.Remediations, rule.PostAudits, rule.Rollbacks} {
+			for _, script := range scripts {
+				if script == "" {
+					// remediation without rollback
+					continue
+				}
+				if err := checkExecutable(filepath.Join(remediationsPath, script)); err != nil {
+					report(script, "rule %s: %s", rule.RuleName, err)
+				}
+			}
+		}
+
+		if rule.Workflow != "" {
+			if _, ok := workflows[rule.Workflow]; !ok && workflows != nil {
+				report("Workflow", "rule %s references unknown workflow %s", rule.RuleName, rule.Workflow)
+			} else if workflows == nil {
+				report("Workflow", "rule %s references workflow %s but no workflows file is set", rule.RuleName, rule.Workflow)
+			}
+			if len(rule.Steps) > 0 {
+				report("Workflow", "rule %s defines both Workflow and Steps", rule.RuleName)
+			}
+		}
+		if len(rule.Steps) > 0 {
+			if err := CheckSteps(rule.Steps); err != nil {
+				report("Steps", "rule %s: %s", rule.RuleName, err)
+			}
+			for _, step := range rule.Steps {
+				for _, script := range []string{step.Cmd, step.Rollback} {
+					if script == "" {
+						continue
+					}
+					if err := checkExecutable(filepath.Join(remediationsPath, script)); err != nil {
+						report(script, "rule %s: %s", rule.RuleName, err)
+					}
+				}
+			}
+		}
+		if (rule.Workflow != "" || len(rule.Steps) > 0) && len(rule.PreAudits)+len(rule.Remediations)+len(rule.PostAudits)+len(rule.Rollbacks) > 0 {
+			report("Workflow", "rule %s defines both a workflow and PreAudits/Remediations/PostAudits/Rollbacks", rule.RuleName)
+		}
+	}
+	return errs
+}
+
+// validateWorkflows checks every workflow of the workflows file and returns them by name,
+// nil if there is no workflows file.
+func validateWorkflows(path string, remediationsPath string) (map[string]Workflow, []ValidationError) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, []ValidationError{{File: path, Msg: err.Error()}}
+	}
+
+	var workflows []Workflow
+	if err := yaml.Unmarshal(content, &workflows); err != nil {
+		return nil, []ValidationError{parseError(path, err)}
+	}
+	lines := strings.Split(string(content), "\n")
+	starts := ruleStartLines(lines, len(workflows))
+
+	var errs []ValidationError
+	byName := make(map[string]Workflow, len(workflows))
+	for i, workflow := range workflows {
+		from, to := starts[i], len(lines)
+		if i+1 < len(starts) {
+			to = starts[i+1]
+		}
+		report := func(key string, format string, args ...interface{}) {
+			errs = append(errs, ValidationError{File: path, Line: findKey(lines, from, to, key), Msg: fmt.Sprintf(format, args...)})
+		}
+
+		if _, ok := byName[workflow.Name]; ok {
+			report("Name", "workflow name %s already used", workflow.Name)
+		}
+		byName[workflow.Name] = workflow
+
+		if err := CheckSteps(workflow.Steps); err != nil {
+			report("Steps", "workflow %s: %s", workflow.Name, err)
+		}
+		for _, step := range workflow.Steps {
+			if err := checkExecutable(filepath.Join(remediationsPath, step.Cmd)); err != nil {
+				report("Cmd: "+step.Cmd, "workflow %s: %s", workflow.Name, err)
+			}
+			if step.Rollback == "" {
+				continue
+			}
+			if err := checkExecutable(filepath.Join(remediationsPath, step.Rollback)); err != nil {
+				report("Rollback: "+step.Rollback, "workflow %s: %s", workflow.Name, err)
+			}
+		}
+	}
+	return byName, errs
+}
+
+// checkExecutable returns an error unless path is an executable regular file.
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("script %s not found", path)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("script %s is not executable", path)
+	}
+	return nil
+}
+
+// parseError converts a yaml parsing error into a ValidationError, keeping the line
+// reported by the yaml parser.
+func parseError(path string, err error) ValidationError {
+	verr := ValidationError{File: path, Msg: err.Error()}
+	if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+		verr.Line, _ = strconv.Atoi(match[1])
+	}
+	return verr
+}
+
+// ruleStartLines returns the 0-based index of the line where each of the count rules
+// starts, i.e. the top level "- " sequence entries of the rules file.
+func ruleStartLines(lines []string, count int) []int {
+	indent := -1
+	var starts []int
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if !strings.HasPrefix(trimmed, "- ") && trimmed != "-" {
+			continue
+		}
+		lineIndent := len(line) - len(trimmed)
+		if indent == -1 || lineIndent < indent {
+			indent = lineIndent
+			starts = starts[:0]
+		}
+		if lineIndent == indent {
+			starts = append(starts, i)
+		}
+	}
+	for len(starts) < count {
+		starts = append(starts, 0)
+	}
+	return starts
+}
+
+// findKey returns the 1-based number of the first line in lines[from:to] that
+// defines key (or lists it as a sequence item), the first line of the range otherwise.
+func findKey(lines []string, from, to int, key string) int {
+	for i := from; i < to && i < len(lines); i++ {
+		trimmed := strings.TrimLeft(strings.TrimSpace(lines[i]), "- ")
+		if strings.HasPrefix(trimmed, key+":") || trimmed == key {
+			return i + 1
+		}
+	}
+	return from + 1
+}
@@ -0,0 +1,275 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package confighandler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validConfig sets every key of RequiredConfigKeys.
+const validConfig = `QUEUE_LOG: logs
+QUEUE_INCIDENT: incidents
+RABBITMQ_HOST: localhost
+RABBITMQ_PORT: 5672
+RABBITMQ_USER: guest
+RABBITMQ_PASS: guest
+RULESFILE: rules.yaml
+`
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		// errs are the expected errors, as "<file>:<line>: <message>".
+		errs []string
+	}{
+		{
+			name:   "valid config",
+			config: validConfig + "BASEREG: '(?<hostname>\\w+) .*'\n",
+		},
+		{
+			name:   "missing key",
+			config: strings.Replace(validConfig, "RABBITMQ_USER: guest\n", "", 1),
+			errs:   []string{"config.yaml:0: missing required key RABBITMQ_USER"},
+		},
+		{
+			name:   "empty keys",
+			config: strings.Replace(strings.Replace(validConfig, "localhost", "", 1), "rules.yaml", "''", 1),
+			errs: []string{
+				"config.yaml:3: required key RABBITMQ_HOST is empty",
+				"config.yaml:7: required key RULESFILE is empty",
+			},
+		},
+		{
+			name:   "bad BASEREG",
+			config: validConfig + "BASEREG: '(?<hostname>\\w+ .*'\n",
+			errs:   []string{"config.yaml:8: BASEREG does not compile: error parsing regexp: missing closing ): `(?P<hostname>\\w+ .*`"},
+		},
+		{
+			name:   "broken yaml",
+			config: validConfig + "BASEREG: [\n",
+			errs:   []string{"config.yaml:8: yaml: line 8: did not find expected node content"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := ioutil.WriteFile(path, []byte(test.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			checkValidationErrors(t, ValidateConfig(path), "", test.errs)
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	remediations := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"ok.sh": 0755, "undo.sh": 0755, "noexec.sh": 0644} {
+		if err := ioutil.WriteFile(filepath.Join(remediations, name), []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		rules     string
+		workflows string
+		// errs are the expected errors, as "<file>:<line>: <message>", $DIR standing
+		// for the remediations directory.
+		errs []string
+	}{
+		{
+			name: "valid rules",
+			rules: `- RuleName: down
+  DeviceType: ARISTA
+  Regex: 'Interface (?<interface>\S+), changed state to down'
+  ClearRegex: 'Interface (?P<interface>\S+), changed state to up'
+  HoldDown: 30s
+  Mode: dryrun
+  Remediations: [ok.sh]
+  Rollbacks: [undo.sh]
+- RuleName: bgp
+  DeviceType: JUNOS
+  Regex: 'BGP peer (?<peer>\S+) down'
+  Workflow: drain
+`,
+			workflows: `- Name: drain
+  Steps:
+    - {Name: drain, Cmd: ok.sh, Rollback: undo.sh}
+`,
+		},
+		{
+			name: "missing and duplicated names",
+			rules: `- DeviceType: ARISTA
+  Regex: down
+- RuleName: down
+  DeviceType: ARISTA
+  Regex: down
+- RuleName: down
+  DeviceType: ARISTA
+  Regex: down again
+`,
+			errs: []string{
+				"rules.yaml:1: rule #1 has no RuleName",
+				"rules.yaml:6: rule name down already used on line 3",
+			},
+		},
+		{
+			name: "regexes",
+			rules: `- RuleName: noregex
+  DeviceType: ARISTA
+- RuleName: badregex
+  DeviceType: ARISTA
+  Regex: 'state to (down'
+  ClearRegex: 'state to [up'
+`,
+			errs: []string{
+				"rules.yaml:1: rule noregex has no Regex",
+				"rules.yaml:5: rule badregex regex does not compile: error parsing regexp: missing closing ): `state to (down`",
+				"rules.yaml:6: rule badregex clear regex does not compile: error parsing regexp: missing closing ]: `[up`",
+				"rules.yaml:6: rule badregex has a ClearRegex but no HoldDown",
+			},
+		},
+		{
+			name: "device type, mode and retried phases",
+			rules: `- RuleName: down
+  DeviceType: CISCO
+  Regex: down
+  Mode: test
+  Retry:
+    Phases: [preaudit, rollback]
+`,
+			errs: []string{
+				`rules.yaml:2: rule down has unknown DeviceType "CISCO"`,
+				"rules.yaml:4: rule down has unknown Mode test",
+				"rules.yaml:6: rule down retries unknown phase rollback",
+			},
+		},
+		{
+			name: "scripts",
+			rules: `- RuleName: down
+  DeviceType: ARISTA
+  Regex: down
+  PreAudits:
+    - missing.sh
+  Remediations:
+    - ok.sh
+  PostAudits:
+    - noexec.sh
+  Rollbacks:
+    - undo.sh
+    - undo.sh
+`,
+			errs: []string{
+				"rules.yaml:10: rule down has more Rollbacks than Remediations",
+				"rules.yaml:5: rule down: script $DIR/missing.sh not found",
+				"rules.yaml:9: rule down: script $DIR/noexec.sh is not executable",
+			},
+		},
+		{
+			name: "unknown workflow",
+			rules: `- RuleName: down
+  DeviceType: ARISTA
+  Regex: down
+  Workflow: bounce
+`,
+			workflows: `- Name: drain
+  Steps:
+    - {Name: drain, Cmd: ok.sh}
+`,
+			errs: []string{"rules.yaml:4: rule down references unknown workflow bounce"},
+		},
+		{
+			name: "workflow without workflows file",
+			rules: `- RuleName: down
+  DeviceType: ARISTA
+  Regex: down
+  Workflow: bounce
+`,
+			errs: []string{"rules.yaml:4: rule down references workflow bounce but no workflows file is set"},
+		},
+		{
+			name: "workflow and scripts",
+			rules: `- RuleName: down
+  DeviceType: ARISTA
+  Regex: down
+  Remediations: [ok.sh]
+  Steps:
+    - {Name: bounce, Cmd: ok.sh}
+    - {Name: bounce, Cmd: missing.sh}
+`,
+			errs: []string{
+				"rules.yaml:5: rule down: duplicated step name bounce",
+				"rules.yaml:1: rule down: script $DIR/missing.sh not found",
+				"rules.yaml:1: rule down defines both a workflow and PreAudits/Remediations/PostAudits/Rollbacks",
+			},
+		},
+		{
+			name: "invalid workflows",
+			rules: `- RuleName: down
+  DeviceType: ARISTA
+  Regex: down
+  Workflow: drain
+`,
+			workflows: `- Name: drain
+  Steps:
+    - {Name: drain, Cmd: ok.sh}
+- Name: drain
+  Steps:
+    - {Name: drain, Cmd: ok.sh, DependsOn: [wait]}
+    - {Name: undrain, Cmd: missing.sh}
+`,
+			errs: []string{
+				"workflows.yaml:4: workflow name drain already used",
+				"workflows.yaml:5: workflow drain: step drain depends on unknown step wait",
+				"workflows.yaml:4: workflow drain: script $DIR/missing.sh not found",
+			},
+		},
+		{
+			name:  "broken yaml",
+			rules: "- RuleName: down\n  Regex: [down\n",
+			errs:  []string{"rules.yaml:2: yaml: line 2: did not find expected ',' or ']'"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "rules.yaml")
+			if err := ioutil.WriteFile(path, []byte(test.rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+			workflowsPath := ""
+			if test.workflows != "" {
+				workflowsPath = filepath.Join(dir, "workflows.yaml")
+				if err := ioutil.WriteFile(workflowsPath, []byte(test.workflows), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkValidationErrors(t, ValidateRules(path, workflowsPath, remediations), remediations, test.errs)
+		})
+	}
+}
+
+// checkValidationErrors compares errs to want, "<file>:<line>: <message>" strings
+// where $DIR stands for dir.
+func checkValidationErrors(t *testing.T, errs []ValidationError, dir string, want []string) {
+	t.Helper()
+	var got []string
+	for _, err := range errs {
+		msg := strings.ReplaceAll(err.Msg, dir+"/", "$DIR/")
+		got = append(got, fmt.Sprintf("%s:%d: %s", filepath.Base(err.File), err.Line, msg))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
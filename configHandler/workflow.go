@@ -0,0 +1,160 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package confighandler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Phases a workflow step can belong to.
+const (
+	PhasePreAudit    = "preaudit"
+	PhaseRemediation = "remediation"
+	PhasePostAudit   = "postaudit"
+	// PhaseRollback is the phase of the rollbacks, it cannot be set on a step.
+	PhaseRollback = "rollback"
+)
+
+// Execution modes of a rule.
+const (
+	ModeLive      = "live"
+	ModeDryRun    = "dryrun"
+	ModeAuditOnly = "audit-only"
+)
+
+// GetWorkflows reads the yaml file containing the workflows and returns them by name
+func GetWorkflows(path string) (map[string]Workflow, error) {
+	var workflows []Workflow
+	yamlFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(yamlFile, &workflows)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]Workflow, len(workflows))
+	for _, workflow := range workflows {
+		if _, ok := byName[workflow.Name]; ok {
+			return nil, fmt.Errorf("duplicated workflow name %s", workflow.Name)
+		}
+		byName[workflow.Name] = workflow
+	}
+	return byName, nil
+}
+
+// ResolveWorkflows copies into each rule referencing a workflow the steps of that
+// workflow, and checks the steps of every rule form a valid workflow.
+func ResolveWorkflows(rules []Rule, workflows map[string]Workflow) error {
+	for i, rule := range rules {
+		if rule.Workflow != "" {
+			if len(rule.Steps) > 0 {
+				return fmt.Errorf("rule %s defines both Workflow and Steps", rule.RuleName)
+			}
+			workflow, ok := workflows[rule.Workflow]
+			if !ok {
+				return fmt.Errorf("rule %s references unknown workflow %s", rule.RuleName, rule.Workflow)
+			}
+			rules[i].Steps = workflow.Steps
+		}
+		if len(rule.Rollbacks) > len(rule.Remediations) {
+			return fmt.Errorf("rule %s has more Rollbacks than Remediations", rule.RuleName)
+		}
+		if len(rules[i].Steps) == 0 {
+			continue
+		}
+		if len(rule.PreAudits)+len(rule.Remediations)+len(rule.PostAudits)+len(rule.Rollbacks) > 0 {
+			return fmt.Errorf("rule %s defines both a workflow and PreAudits/Remediations/PostAudits/Rollbacks", rule.RuleName)
+		}
+		if err := CheckSteps(rules[i].Steps); err != nil {
+			return fmt.Errorf("rule %s: %s", rule.RuleName, err)
+		}
+	}
+	return nil
+}
+
+// CheckSteps checks steps form a valid workflow: names are unique, phases known,
+// only remediations have a rollback, dependencies exist and do not form a cycle,
+// and conditions refer to a dependency with a key that can vary (see StepCondition).
+func CheckSteps(steps []WorkflowStep) error {
+	byName := make(map[string]WorkflowStep, len(steps))
+	for _, step := range steps {
+		if step.Name == "" || step.Cmd == "" {
+			return fmt.Errorf("every step needs a Name and a Cmd")
+		}
+		if _, ok := byName[step.Name]; ok {
+			return fmt.Errorf("duplicated step name %s", step.Name)
+		}
+		switch step.Phase {
+		case "", PhasePreAudit, PhaseRemediation, PhasePostAudit:
+		default:
+			return fmt.Errorf("step %s has unknown phase %s", step.Name, step.Phase)
+		}
+		if step.Rollback != "" && step.Phase != "" && step.Phase != PhaseRemediation {
+			return fmt.Errorf("step %s has a rollback but is not a remediation", step.Name)
+		}
+		byName[step.Name] = step
+	}
+
+	for _, step := range steps {
+		dependsOn := make(map[string]bool, len(step.DependsOn))
+		for _, dependency := range step.DependsOn {
+			if _, ok := byName[dependency]; !ok {
+				return fmt.Errorf("step %s depends on unknown step %s", step.Name, dependency)
+			}
+			dependsOn[dependency] = true
+		}
+		if step.When == nil {
+			continue
+		}
+		if !dependsOn[step.When.Step] {
+			return fmt.Errorf("step %s condition refers to %s, which is not one of its dependencies", step.Name, step.When.Step)
+		}
+		switch key := step.When.Key; {
+		case key == "result" || (strings.HasPrefix(key, "data.") && key != "data."):
+		case key == "" || key == "passed" || key == "success":
+			return fmt.Errorf("step %s condition on %s is always true, steps only start once their dependencies passed: use result or data.<key>", step.Name, step.When.Step)
+		default:
+			return fmt.Errorf("step %s condition has unknown key %s, expecting result or data.<key>", step.Name, key)
+		}
+	}
+
+	// depth first search, a step met again while still being visited closes a cycle
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(steps))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("steps dependencies form a cycle through %s", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dependency := range byName[name].DependsOn {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, step := range steps {
+		if err := visit(step.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
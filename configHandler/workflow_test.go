@@ -0,0 +1,173 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package confighandler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckSteps(t *testing.T) {
+	when := func(step string, key string) *StepCondition {
+		return &StepCondition{Step: step, Key: key, Equals: "gone"}
+	}
+	tests := []struct {
+		name  string
+		steps []WorkflowStep
+		// part of the error, empty if the steps are valid
+		err string
+	}{
+		{
+			name: "valid",
+			steps: []WorkflowStep{
+				{Name: "audit", Cmd: "audit.py", Phase: PhasePreAudit},
+				{Name: "kill", Cmd: "kill.py", DependsOn: []string{"audit"}, Rollback: "start.py"},
+				{Name: "check", Cmd: "check.py", Phase: PhasePostAudit, DependsOn: []string{"kill"}, When: when("kill", "data.process")},
+				{Name: "report", Cmd: "report.py", Phase: PhasePostAudit, DependsOn: []string{"kill", "check"}, When: when("check", "result")},
+			},
+		},
+		{
+			name:  "no name",
+			steps: []WorkflowStep{{Cmd: "audit.py"}},
+			err:   "needs a Name and a Cmd",
+		},
+		{
+			name:  "no command",
+			steps: []WorkflowStep{{Name: "audit"}},
+			err:   "needs a Name and a Cmd",
+		},
+		{
+			name:  "duplicated name",
+			steps: []WorkflowStep{{Name: "audit", Cmd: "a.py"}, {Name: "audit", Cmd: "b.py"}},
+			err:   "duplicated step name audit",
+		},
+		{
+			name:  "unknown phase",
+			steps: []WorkflowStep{{Name: "audit", Cmd: "audit.py", Phase: PhaseRollback}},
+			err:   "unknown phase rollback",
+		},
+		{
+			name:  "rollback of an audit",
+			steps: []WorkflowStep{{Name: "audit", Cmd: "audit.py", Phase: PhasePreAudit, Rollback: "undo.py"}},
+			err:   "not a remediation",
+		},
+		{
+			name:  "unknown dependency",
+			steps: []WorkflowStep{{Name: "kill", Cmd: "kill.py", DependsOn: []string{"audit"}}},
+			err:   "unknown step audit",
+		},
+		{
+			name: "condition on a step that is not a dependency",
+			steps: []WorkflowStep{
+				{Name: "audit", Cmd: "audit.py"},
+				{Name: "kill", Cmd: "kill.py", When: when("audit", "result")},
+			},
+			err: "not one of its dependencies",
+		},
+		{
+			name: "condition without key",
+			steps: []WorkflowStep{
+				{Name: "audit", Cmd: "audit.py"},
+				{Name: "kill", Cmd: "kill.py", DependsOn: []string{"audit"}, When: when("audit", "")},
+			},
+			err: "always true",
+		},
+		{
+			name: "condition on passed",
+			steps: []WorkflowStep{
+				{Name: "audit", Cmd: "audit.py"},
+				{Name: "kill", Cmd: "kill.py", DependsOn: []string{"audit"}, When: when("audit", "passed")},
+			},
+			err: "always true",
+		},
+		{
+			name: "condition on success",
+			steps: []WorkflowStep{
+				{Name: "audit", Cmd: "audit.py"},
+				{Name: "kill", Cmd: "kill.py", DependsOn: []string{"audit"}, When: when("audit", "success")},
+			},
+			err: "always true",
+		},
+		{
+			name: "condition on an unknown key",
+			steps: []WorkflowStep{
+				{Name: "audit", Cmd: "audit.py"},
+				{Name: "kill", Cmd: "kill.py", DependsOn: []string{"audit"}, When: when("audit", "output")},
+			},
+			err: "unknown key output",
+		},
+		{
+			name: "condition on data without key",
+			steps: []WorkflowStep{
+				{Name: "audit", Cmd: "audit.py"},
+				{Name: "kill", Cmd: "kill.py", DependsOn: []string{"audit"}, When: when("audit", "data.")},
+			},
+			err: "unknown key data.",
+		},
+		{
+			name: "cycle",
+			steps: []WorkflowStep{
+				{Name: "a", Cmd: "a.py", DependsOn: []string{"c"}},
+				{Name: "b", Cmd: "b.py", DependsOn: []string{"a"}},
+				{Name: "c", Cmd: "c.py", DependsOn: []string{"b"}},
+			},
+			err: "cycle",
+		},
+		{
+			name:  "depends on itself",
+			steps: []WorkflowStep{{Name: "a", Cmd: "a.py", DependsOn: []string{"a"}}},
+			err:   "cycle through a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckSteps(test.steps)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("CheckSteps error: %s", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("CheckSteps error = %v, want an error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestResolveWorkflows(t *testing.T) {
+	workflows := map[string]Workflow{
+		"restart": {Name: "restart", Steps: []WorkflowStep{{Name: "restart", Cmd: "restart.py"}}},
+	}
+	tests := []struct {
+		name string
+		rule Rule
+		// steps of the rule once resolved
+		steps int
+		err   string
+	}{
+		{name: "phases", rule: Rule{RuleName: "rule", PreAudits: []string{"audit.py"}, Remediations: []string{"fix.py"}}},
+		{name: "workflow", rule: Rule{RuleName: "rule", Workflow: "restart"}, steps: 1},
+		{name: "steps", rule: Rule{RuleName: "rule", Steps: []WorkflowStep{{Name: "fix", Cmd: "fix.py"}}}, steps: 1},
+		{name: "unknown workflow", rule: Rule{RuleName: "rule", Workflow: "reboot"}, err: "unknown workflow reboot"},
+		{name: "workflow and steps", rule: Rule{RuleName: "rule", Workflow: "restart", Steps: []WorkflowStep{{Name: "fix", Cmd: "fix.py"}}}, err: "both Workflow and Steps"},
+		{name: "workflow and phases", rule: Rule{RuleName: "rule", Workflow: "restart", Remediations: []string{"fix.py"}}, err: "both a workflow and"},
+		{name: "too many rollbacks", rule: Rule{RuleName: "rule", Remediations: []string{"fix.py"}, Rollbacks: []string{"a.py", "b.py"}}, err: "more Rollbacks"},
+		{name: "invalid steps", rule: Rule{RuleName: "rule", Steps: []WorkflowStep{{Name: "fix"}}}, err: "rule rule: every step"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := []Rule{test.rule}
+			err := ResolveWorkflows(rules, workflows)
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("ResolveWorkflows error: %s", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Fatalf("ResolveWorkflows error = %v, want an error containing %q", err, test.err)
+			case test.err == "" && len(rules[0].Steps) != test.steps:
+				t.Errorf("%d steps, want %d", len(rules[0].Steps), test.steps)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/approvals"
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// How often the approval requests are checked for decisions and expiry.
+const approvalsPollInterval = 10 * time.Second
+
+// How long incidents wait for approval when neither the rule nor the config say.
+const defaultApprovalTimeout = time.Hour
+
+// SetApprovals sets where incidents wait for approval, for how long by default
+// (zero keeping the default) and the script notified of the approval events.
+func (executor *Executor) SetApprovals(store *approvals.Store, timeout time.Duration, notify string) {
+	executor.approvals = store
+	if timeout > 0 {
+		executor.approvalTimeout = timeout
+	}
+	executor.approvalNotify = notify
+}
+
+// requestApproval runs the pre-audits of an incident whose rule RequiresApproval and, if
+// they pass, parks it in the approvals store and acks it. Failures are handled as usual.
+func (executor *Executor) requestApproval(ctx context.Context, incident *lib.Incident, job *amqp.Delivery, steps []*lib.Step) error {
+	if executor.approvals == nil {
+		return executor.deadLetter(incident, job, "rule requires approval but APPROVALS_DIR is not set")
+	}
+
+	var preAudits []*lib.Step
+	for _, step := range steps {
+		if step.Phase == confighandler.PhasePreAudit {
+			preAudits = append(preAudits, step)
+		}
+	}
+	run := executor.runWorkflow(ctx, incident, preAudits)
+	if run.Failed != nil {
+		if ctx.Err() != nil {
+			return executor.deadLetter(incident, job, failureReason(run)+", device lease lost")
+		}
+		return executor.retryOrDeadLetter(incident, job, run)
+	}
+
+	transition(incident, lib.StateAwaitingApproval)
+	timeout := incident.Rule.ApprovalTimeout
+	if timeout <= 0 {
+		timeout = executor.approvalTimeout
+	}
+	request := &approvals.Request{
+		ID:        incident.ID,
+		Incident:  *incident,
+		Device:    deviceKey(incident),
+		Requested: time.Now(),
+		Expires:   time.Now().Add(timeout),
+		Status:    approvals.StatusPending,
+		PreAudits: make(map[string]json.RawMessage, len(run.Completed)),
+	}
+	for _, step := range run.Completed {
+		output, err := json.Marshal(run.Results[step.Name].ProcessOutput)
+		if err != nil {
+			glog.Errorf("Incident %s: cannot record the output of pre-audit %s: %s", incident.ID, step.Name, err)
+			continue
+		}
+		request.PreAudits[step.Name] = output
+	}
+
+	if err := executor.approvals.Add(request); err != nil {
+		glog.Errorf("Incident %s: cannot store the approval request, requeuing: %s", incident.ID, err)
+		return job.Nack(false, true)
+	}
+	glog.Warningf("Incident %s of rule %s on %s waits for approval until %s",
+		incident.ID, incident.Rule.RuleName, request.Device, request.Expires.Format(time.RFC3339))
+	executor.notifyApproval(request, approvals.StatusPending)
+	event := executor.newEvent(incident, lib.EventAwaitingApproval)
+	event.Attempt = jobAttempt(job)
+	executor.emit(event)
+	return job.Ack(false)
+}
+
+// approvedSteps takes out of steps the pre-audits of an approved incident, as they ran
+// before the approval: the ones that passed are added to the completed steps of run with
+// their recorded output, the other ones to the skipped steps. The remaining steps are returned.
+func approvedSteps(incident *lib.Incident, steps []*lib.Step, run *workflowRun, done map[string]bool) []*lib.Step {
+	remaining := make([]*lib.Step, 0, len(steps))
+	for _, step := range steps {
+		if step.Phase != confighandler.PhasePreAudit {
+			remaining = append(remaining, step)
+			continue
+		}
+		done[step.Name] = true
+
+		output, passed := incident.Approval.PreAudits[step.Name]
+		if !passed {
+			run.Skipped = append(run.Skipped, step)
+			continue
+		}
+		result := &Result{Step: step.Name, ExitCode: tOK}
+		if err := json.Unmarshal(output, &result.ProcessOutput); err != nil {
+			glog.Errorf("Incident %s: cannot read the output of pre-audit %s: %s", incident.ID, step.Name, err)
+		}
+		run.Results[step.Name] = result
+		run.Completed = append(run.Completed, step)
+	}
+	return remaining
+}
+
+// WatchApprovals handles, every interval, the approval requests that were decided or expired.
+func (executor *Executor) WatchApprovals(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			executor.handleApprovals()
+		}
+	}()
+}
+
+// handleApprovals releases the approved incidents to the incident queue, drops the denied
+// ones and dead-letters the ones nobody decided on in time. Each request is claimed first,
+// so that only one executor handles it.
+func (executor *Executor) handleApprovals() {
+	requests, err := executor.approvals.List()
+	if err != nil {
+		glog.Errorf("Cannot list approval requests: %s", err)
+		return
+	}
+
+	for _, request := range requests {
+		if request.Status == approvals.StatusPending && time.Now().Before(request.Expires) {
+			continue
+		}
+		claimed, err := executor.approvals.Claim(request.ID)
+		if err == approvals.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			glog.Errorf("Cannot claim approval request %s: %s", request.ID, err)
+			continue
+		}
+
+		if err := executor.handleApproval(claimed); err != nil {
+			glog.Errorf("Incident %s: cannot handle the %s approval request, retrying later: %s", claimed.ID, claimed.Status, err)
+			if err := executor.approvals.Unclaim(claimed.ID); err != nil {
+				glog.Errorf("Cannot unclaim approval request %s: %s", claimed.ID, err)
+			}
+			continue
+		}
+		if err := executor.approvals.Done(claimed.ID); err != nil {
+			glog.Errorf("Cannot remove approval request %s: %s", claimed.ID, err)
+		}
+	}
+}
+
+// handleApproval acts on a claimed request, see handleApprovals.
+func (executor *Executor) handleApproval(request *approvals.Request) error {
+	incident := request.Incident
+	eventType, reason := lib.EventApproved, request.Reason
+
+	switch request.Status {
+	case approvals.StatusApproved:
+		transition(&incident, lib.StateQueued)
+		incident.Approval = &lib.Approval{
+			By:        request.DecidedBy,
+			At:        request.DecidedAt,
+			PreAudits: request.PreAudits,
+		}
+		body, err := incident.IncidentToJSON()
+		if err != nil {
+			return err
+		}
+		if err := executor.publish(executor.queue, "text/plain", body, nil); err != nil {
+			return err
+		}
+		glog.Infof("Incident %s approved by %s, released to remediation", incident.ID, request.DecidedBy)
+
+	case approvals.StatusDenied:
+		glog.Warningf("Incident %s denied by %s: %s", incident.ID, request.DecidedBy, request.Reason)
+		transition(&incident, lib.StateFailed)
+		eventType = lib.EventDenied
+
+	default:
+		request.Status = approvals.StatusExpired
+		// dead-lettered as is, to be replayed as a new request if needed
+		body, err := incident.IncidentToJSON()
+		if err != nil {
+			return err
+		}
+		reason = fmt.Sprintf("approval expired at %s", request.Expires.Format(time.RFC3339))
+		if err := executor.publish(executor.deadLetterQueue, "text/plain", body, amqp.Table{failureReasonHeader: reason}); err != nil {
+			return err
+		}
+		glog.Errorf("Incident %s sent to dead-letter queue %s: %s", incident.ID, executor.deadLetterQueue, reason)
+		transition(&incident, lib.StateFailed)
+		eventType = lib.EventExpired
+	}
+
+	event := executor.newEvent(&incident, eventType)
+	event.By = request.DecidedBy
+	event.Reason = reason
+	executor.emit(event)
+	executor.notifyApproval(request, request.Status)
+	return nil
+}
+
+// notifyApproval runs the APPROVAL_NOTIFY script, if set, with the --event=<status>,
+// --incident=<ID> and --rule=<name> arguments and the request as JSON on stdin.
+// The event is the status of the request: pending when the approval is requested.
+func (executor *Executor) notifyApproval(request *approvals.Request, event string) {
+	if executor.approvalNotify == "" {
+		return
+	}
+
+	input, err := json.Marshal(request)
+	if err != nil {
+		glog.Errorf("Incident %s: cannot notify approval event %s: %s", request.ID, event, err)
+		return
+	}
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), executor.timeout)
+	defer ctxCancel()
+	cmd := exec.CommandContext(ctx, executor.remediationsPath+executor.approvalNotify,
+		"--event="+event,
+		"--incident="+request.ID,
+		"--rule="+request.Incident.Rule.RuleName)
+	cmd.Stdin = bytes.NewReader(input)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		glog.Errorf("Incident %s: approval notification %s failed: %s: %s", request.ID, event, err, output)
+	}
+}
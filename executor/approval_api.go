@@ -0,0 +1,91 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/approvals"
+)
+
+// ServeApprovals serves the approvals API on addr, in the background:
+//
+//	GET  /approvals                                  lists the requests
+//	GET  /approvals/<id>                             returns a request
+//	POST /approvals/<id>/approve?by=<name>           approves a pending request
+//	POST /approvals/<id>/deny?by=<name>&reason=<why> denies a pending request
+//
+// Responses are the requests as JSON. The API has no authentication of its own.
+func (executor *Executor) ServeApprovals(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/approvals", executor.listApprovals)
+	mux.HandleFunc("/approvals/", executor.approval)
+
+	go func() {
+		glog.Infof("Serving the approvals API on %s", addr)
+		glog.Fatal(http.ListenAndServe(addr, mux))
+	}()
+}
+
+func (executor *Executor) listApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requests, err := executor.approvals.List()
+	writeApprovalResponse(w, requests, err)
+}
+
+func (executor *Executor) approval(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/approvals/"), "/")
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		request, err := executor.approvals.Get(parts[0])
+		writeApprovalResponse(w, request, err)
+
+	case len(parts) == 2 && r.Method == http.MethodPost && (parts[1] == "approve" || parts[1] == "deny"):
+		by := r.FormValue("by")
+		if by == "" {
+			http.Error(w, "by is required", http.StatusBadRequest)
+			return
+		}
+		request, err := executor.approvals.Decide(parts[0], parts[1] == "approve", by, r.FormValue("reason"))
+		if err == nil {
+			glog.Infof("Incident %s %s by %s through the API", request.ID, request.Status, by)
+		}
+		writeApprovalResponse(w, request, err)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeApprovalResponse writes value as JSON, or err with a matching status code.
+func writeApprovalResponse(w http.ResponseWriter, value interface{}, err error) {
+	switch err {
+	case nil:
+	case approvals.ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case approvals.ErrDecided, approvals.ErrExpired:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		glog.Warningf("Error writing approvals API response: %s", err)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// defaultBreakerPolicy opens a breaker after 10 failures in a row, for 10 minutes.
+var defaultBreakerPolicy = confighandler.BreakerPolicy{
+	Window:       10,
+	FailureRatio: 1,
+	Cooldown:     10 * time.Minute,
+}
+
+// Breaker states.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// breakerEvents are the routing keys of the results exchange events feeding the breakers.
+var breakerEvents = []string{
+	lib.EventStep + ".#",
+	lib.EventSucceeded + ".#",
+	lib.EventFailed + ".#",
+	lib.EventRolledBack + ".#",
+	lib.EventBreakerReset + ".#",
+}
+
+// ruleBreaker and scriptBreaker return the breaker keys of a rule and of a script.
+func ruleBreaker(rule string) string {
+	return "rule:" + rule
+}
+
+func scriptBreaker(script string) string {
+	return "script:" + script
+}
+
+// breaker tracks the runs of a rule or a script.
+type breaker struct {
+	// outcomes of the last runs while closed, true for failures, at most Window.
+	outcomes []bool
+	open     bool
+	openedAt time.Time
+	// probedAt is when the last half-open probe was let through.
+	probedAt time.Time
+}
+
+// state returns the state of b: an open breaker is half-open once its Cooldown elapsed.
+func (b *breaker) state(policy confighandler.BreakerPolicy, now time.Time) string {
+	switch {
+	case !b.open:
+		return breakerClosed
+	case now.Sub(b.openedAt) < policy.Cooldown:
+		return breakerOpen
+	}
+	return breakerHalfOpen
+}
+
+// breakers holds the breaker of every rule and script that ran, by key (see ruleBreaker
+// and scriptBreaker), sharing the same policy.
+type breakers struct {
+	mutex  sync.Mutex
+	policy confighandler.BreakerPolicy
+	byKey  map[string]*breaker
+}
+
+// newBreakers returns the breakers of policy, nil if it is disabled. Zero or
+// out of range values default to the ones of defaultBreakerPolicy.
+func newBreakers(policy confighandler.BreakerPolicy) *breakers {
+	if policy.Disabled {
+		return nil
+	}
+	if policy.Window <= 0 {
+		policy.Window = defaultBreakerPolicy.Window
+	}
+	if policy.FailureRatio <= 0 || policy.FailureRatio > 1 {
+		policy.FailureRatio = defaultBreakerPolicy.FailureRatio
+	}
+	if policy.Cooldown <= 0 {
+		policy.Cooldown = defaultBreakerPolicy.Cooldown
+	}
+	return &breakers{policy: policy, byKey: make(map[string]*breaker)}
+}
+
+// allow returns the first of keys whose breaker is open, or half-open with a probe
+// let through less than Cooldown ago, "" if none is. In the latter case the incident
+// is the probe of the half-open breakers of keys. Probes are not coordinated between
+// executors: with RESULTS_EXCHANGE, each executor lets one through per half-open breaker.
+func (breakers *breakers) allow(keys []string, now time.Time) string {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+
+	var probed []*breaker
+	for _, key := range keys {
+		b, ok := breakers.byKey[key]
+		if !ok {
+			continue
+		}
+		switch b.state(breakers.policy, now) {
+		case breakerOpen:
+			return key
+		case breakerHalfOpen:
+			if now.Sub(b.probedAt) < breakers.policy.Cooldown {
+				// waiting for the outcome of the probe
+				return key
+			}
+			probed = append(probed, b)
+		}
+	}
+	for _, b := range probed {
+		b.probedAt = now
+	}
+	return ""
+}
+
+// observe records the outcome of a run of key and returns the new state of its
+// breaker if it changed, "" otherwise. Runs ending while the breaker is open
+// started before it opened, they are ignored.
+func (breakers *breakers) observe(key string, failed bool, now time.Time) string {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+
+	b, ok := breakers.byKey[key]
+	if !ok {
+		b = &breaker{}
+		breakers.byKey[key] = b
+	}
+
+	switch b.state(breakers.policy, now) {
+	case breakerClosed:
+		b.outcomes = append(b.outcomes, failed)
+		if len(b.outcomes) > breakers.policy.Window {
+			b.outcomes = b.outcomes[1:]
+		}
+		if len(b.outcomes) < breakers.policy.Window {
+			return ""
+		}
+		failures := 0
+		for _, outcome := range b.outcomes {
+			if outcome {
+				failures++
+			}
+		}
+		if float64(failures) < breakers.policy.FailureRatio*float64(len(b.outcomes)) {
+			return ""
+		}
+		b.open, b.openedAt, b.outcomes = true, now, nil
+		return breakerOpen
+
+	case breakerHalfOpen:
+		if failed {
+			b.openedAt, b.probedAt = now, time.Time{}
+			return breakerOpen
+		}
+		delete(breakers.byKey, key)
+		return breakerClosed
+	}
+	return ""
+}
+
+// reset closes the breaker of key, forgetting its runs. It reports whether there was one.
+func (breakers *breakers) reset(key string) bool {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+
+	_, ok := breakers.byKey[key]
+	delete(breakers.byKey, key)
+	return ok
+}
+
+// incidentBreakers returns the breaker keys of incident: its rule and every script it can run.
+func incidentBreakers(incident *lib.Incident, steps []*lib.Step) []string {
+	keys := []string{ruleBreaker(incident.Rule.RuleName)}
+	for _, step := range steps {
+		keys = append(keys, scriptBreaker(step.Command.Cmd))
+		if step.Rollback != nil {
+			keys = append(keys, scriptBreaker(step.Rollback.Cmd))
+		}
+	}
+	return keys
+}
+
+// observe feeds the breakers with event, from this executor or, when RESULTS_EXCHANGE
+// is set, from any executor (see watchBreakers):
+//   - a step counts for the breaker of its script, failing if the script did not run
+//     properly, or if it is a remediation or a rollback that did not pass. Simulated
+//     steps are not counted.
+//   - a succeeded, failed or rolledback incident counts once for the breaker of its
+//     rule, failing unless it succeeded, provided its last attempt went as far as
+//     remediating. Retried attempts are not counted, only the outcome of the last one.
+func (executor *Executor) observe(event *lib.Event) {
+	if executor.breakers == nil {
+		return
+	}
+
+	var key string
+	var failed bool
+	switch event.Type {
+	case lib.EventStep:
+		step := event.Step
+		if step == nil || step.Simulated || step.Script == "" {
+			return
+		}
+		key = scriptBreaker(step.Script)
+		failed = step.ExitCode != int(tOK) ||
+			(!step.Passed && (step.Phase == confighandler.PhaseRemediation || step.Phase == confighandler.PhaseRollback))
+
+	case lib.EventSucceeded, lib.EventFailed, lib.EventRolledBack:
+		if !remediated(event.Transitions) {
+			return
+		}
+		key = ruleBreaker(event.Rule)
+		failed = event.Type != lib.EventSucceeded
+
+	case lib.EventBreakerReset:
+		if executor.breakers.reset(event.Breaker) {
+			glog.Warningf("Breaker %s reset by %s", event.Breaker, event.By)
+		}
+		return
+
+	default:
+		return
+	}
+
+	switch executor.breakers.observe(key, failed, time.Now()) {
+	case breakerOpen:
+		glog.Errorf("Breaker %s open, quarantining its incidents for %s", key, executor.breakers.policy.Cooldown)
+		executor.emitBreaker(lib.EventBreakerOpened, key, event)
+	case breakerClosed:
+		glog.Warningf("Breaker %s closed after a successful probe", key)
+		executor.emitBreaker(lib.EventBreakerClosed, key, event)
+	}
+}
+
+// remediated reports whether an incident went as far as remediating in its transitions.
+func remediated(transitions []lib.Transition) bool {
+	for _, transition := range transitions {
+		if transition.State == lib.StateRemediating {
+			return true
+		}
+	}
+	return false
+}
+
+// emitBreaker publishes an event of eventType about the breaker of key, the event
+// that changed its state giving the rule and incident.
+func (executor *Executor) emitBreaker(eventType string, key string, cause *lib.Event) {
+	executor.emit(&lib.Event{
+		Type:       eventType,
+		Time:       time.Now(),
+		Executor:   executor.hostname,
+		IncidentID: cause.IncidentID,
+		Rule:       cause.Rule,
+		Breaker:    key,
+	})
+}
+
+// quarantine publishes the job of an incident whose breaker is open to the quarantine
+// queue, with the reason, and acks it. The job is requeued if that fails.
+func (executor *Executor) quarantine(incident *lib.Incident, job *amqp.Delivery, breaker string) error {
+	reason := fmt.Sprintf("breaker %s is open", breaker)
+	if err := executor.republish(job, executor.quarantineQueue, jobAttempt(job), reason); err != nil {
+		glog.Errorf("Incident %s: cannot quarantine, requeuing: %s", incident.ID, err)
+		return job.Nack(false, true)
+	}
+	glog.Warningf("Incident %s sent to quarantine queue %s: %s", incident.ID, executor.quarantineQueue, reason)
+	event := executor.newEvent(incident, lib.EventQuarantined)
+	event.Attempt = jobAttempt(job)
+	event.Reason = reason
+	event.Breaker = breaker
+	executor.emit(event)
+	return job.Ack(false)
+}
+
+// watchBreakers feeds the breakers with the events of every executor, consumed from
+// a queue of its own bound to the results exchange.
+func (executor *Executor) watchBreakers(connection *amqp.Connection) error {
+	channel, err := connection.Channel()
+	if err != nil {
+		return err
+	}
+	queue, err := channel.QueueDeclare(
+		"",    // name
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return err
+	}
+	for _, key := range breakerEvents {
+		if err := channel.QueueBind(queue.Name, key, executor.resultsExchange, false, nil); err != nil {
+			return err
+		}
+	}
+	deliveries, err := channel.Consume(
+		queue.Name, // queue
+		"",         // consumer
+		true,       // auto-ack
+		true,       // exclusive
+		false,      // no-local
+		false,      // no-wait
+		nil,        // args
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for delivery := range deliveries {
+			var event lib.Event
+			if err := json.Unmarshal(delivery.Body, &event); err != nil {
+				glog.Warningf("Cannot decode event from %s: %s", executor.resultsExchange, err)
+				continue
+			}
+			executor.observe(&event)
+		}
+		glog.Errorf("Stopped watching the breaker events of %s", executor.resultsExchange)
+	}()
+	return nil
+}
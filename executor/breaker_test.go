@@ -0,0 +1,208 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+func TestBreakers(t *testing.T) {
+	const key = "rule:test"
+	policy := confighandler.BreakerPolicy{Window: 4, FailureRatio: 0.75, Cooldown: time.Minute}
+
+	// an action at a time after the start of the test: the outcome of a run of key,
+	// or an incident asking to run it
+	type action struct {
+		after   time.Duration
+		run     bool
+		failed  bool
+		allowed bool
+		// state returned by observe, "" if unchanged
+		state string
+	}
+	observed := func(after time.Duration, failed bool, state string) action {
+		return action{after: after, failed: failed, state: state}
+	}
+	allowed := func(after time.Duration, allowed bool) action {
+		return action{after: after, run: true, allowed: allowed}
+	}
+
+	tests := []struct {
+		name    string
+		actions []action
+	}{
+		{
+			name: "opens once the window is full",
+			actions: []action{
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, ""),
+				allowed(0, true),
+				observed(0, true, breakerOpen),
+				allowed(0, false),
+				allowed(59*time.Second, false),
+			},
+		},
+		{
+			name: "opens at the failure ratio",
+			actions: []action{
+				observed(0, false, ""),
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, breakerOpen),
+			},
+		},
+		{
+			name: "successes keep it closed",
+			actions: []action{
+				observed(0, true, ""),
+				observed(0, false, ""),
+				observed(0, true, ""),
+				observed(0, false, ""),
+				observed(0, true, ""),
+				observed(0, false, ""),
+				allowed(0, true),
+			},
+		},
+		{
+			name: "sliding window",
+			actions: []action{
+				observed(0, false, ""),
+				observed(0, false, ""),
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, breakerOpen),
+			},
+		},
+		{
+			name: "successful probe closes",
+			actions: []action{
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, breakerOpen),
+				allowed(time.Minute, true),
+				allowed(time.Minute, false),
+				observed(time.Minute, false, breakerClosed),
+				allowed(time.Minute, true),
+				allowed(time.Minute, true),
+			},
+		},
+		{
+			name: "failed probe opens again",
+			actions: []action{
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, breakerOpen),
+				allowed(time.Minute, true),
+				observed(time.Minute+time.Second, true, breakerOpen),
+				allowed(time.Minute+time.Second, false),
+				allowed(2*time.Minute, false),
+				allowed(2*time.Minute+time.Second, true),
+			},
+		},
+		{
+			name: "probe without outcome",
+			actions: []action{
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, breakerOpen),
+				allowed(time.Minute, true),
+				allowed(2*time.Minute-time.Second, false),
+				allowed(2*time.Minute, true),
+			},
+		},
+		{
+			name: "runs ending while open are ignored",
+			actions: []action{
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, ""),
+				observed(0, true, breakerOpen),
+				observed(time.Second, false, ""),
+				allowed(time.Second, false),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breakers := newBreakers(policy)
+			start := time.Now()
+			for i, action := range test.actions {
+				now := start.Add(action.after)
+				if action.run {
+					open := breakers.allow([]string{"script:other.sh", key}, now)
+					if allowed := open == ""; allowed != action.allowed {
+						t.Fatalf("action %d: allow = %q, want allowed %t", i, open, action.allowed)
+					}
+					continue
+				}
+				if state := breakers.observe(key, action.failed, now); state != action.state {
+					t.Fatalf("action %d: observe = %q, want %q", i, state, action.state)
+				}
+			}
+		})
+	}
+}
+
+func TestNewBreakers(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy confighandler.BreakerPolicy
+		want   confighandler.BreakerPolicy
+	}{
+		{"defaults", confighandler.BreakerPolicy{}, defaultBreakerPolicy},
+		{"out of range ratio", confighandler.BreakerPolicy{Window: 5, FailureRatio: 2, Cooldown: time.Second}, confighandler.BreakerPolicy{Window: 5, FailureRatio: 1, Cooldown: time.Second}},
+		{"set", confighandler.BreakerPolicy{Window: 5, FailureRatio: 0.5, Cooldown: time.Second}, confighandler.BreakerPolicy{Window: 5, FailureRatio: 0.5, Cooldown: time.Second}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if breakers := newBreakers(test.policy); breakers.policy != test.want {
+				t.Errorf("policy = %+v, want %+v", breakers.policy, test.want)
+			}
+		})
+	}
+	if breakers := newBreakers(confighandler.BreakerPolicy{Disabled: true}); breakers != nil {
+		t.Error("disabled breakers are not nil")
+	}
+}
+
+func TestObserveRuleOutcomes(t *testing.T) {
+	remediating := []lib.Transition{{State: lib.StateQueued}, {State: lib.StateRemediating}}
+	auditing := []lib.Transition{{State: lib.StateQueued}, {State: lib.StateAuditing}}
+	tests := []struct {
+		name        string
+		eventType   string
+		transitions []lib.Transition
+		// whether the incident counts as a failure for the rule breaker
+		counted bool
+	}{
+		{"failed", lib.EventFailed, remediating, true},
+		{"rolled back", lib.EventRolledBack, remediating, true},
+		{"retried", lib.EventRetried, remediating, false},
+		{"failed before remediating", lib.EventFailed, auditing, false},
+		{"succeeded", lib.EventSucceeded, remediating, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executor := NewExecutor("")
+			executor.SetBreakerPolicy(confighandler.BreakerPolicy{Window: 1, FailureRatio: 1, Cooldown: time.Minute})
+			executor.observe(&lib.Event{Type: test.eventType, Rule: "test", Transitions: test.transitions})
+
+			open := executor.breakers.allow([]string{ruleBreaker("test")}, time.Now()) != ""
+			if open != test.counted {
+				t.Errorf("breaker open = %t, want %t", open, test.counted)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// Parameters identifying the device of an incident whose rule has no LockKeys.
+var defaultLockKeys = []string{"hostname"}
+
+// task is an incident to process together with the job it was delivered in.
+type task struct {
+	incident *lib.Incident
+	job      *amqp.Delivery
+	// device is the key of the device the incident acts on, see deviceKey.
+	device string
+}
+
+// deviceKey identifies the device an incident acts on with the values of the LockKeys
+// parameters of its rule. Incidents missing those parameters share the same key.
+func deviceKey(incident *lib.Incident) string {
+	keys := incident.Rule.LockKeys
+	if len(keys) == 0 {
+		keys = defaultLockKeys
+	}
+
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, key+"="+incident.Parameters[key])
+	}
+	return strings.Join(values, ",")
+}
+
+// busyDevices tracks the devices with an incident in progress, so that an executor
+// never processes two incidents of a device at once.
+type busyDevices struct {
+	sync.Mutex
+	busy map[string]bool
+}
+
+func newBusyDevices() *busyDevices {
+	return &busyDevices{busy: make(map[string]bool)}
+}
+
+// acquire marks device as busy. It returns false if it already was, the incident
+// must then wait (see Executor.Run).
+func (devices *busyDevices) acquire(device string) bool {
+	devices.Lock()
+	defer devices.Unlock()
+
+	if devices.busy[device] {
+		return false
+	}
+	devices.busy[device] = true
+	return true
+}
+
+// release is called once the incident in progress on device is done.
+func (devices *busyDevices) release(device string) {
+	devices.Lock()
+	defer devices.Unlock()
+	delete(devices.busy, device)
+}
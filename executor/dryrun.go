@@ -0,0 +1,68 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// Outcomes of the incidents not executed live.
+const (
+	outcomeDryRun    = "dry-run"
+	outcomeAuditOnly = "audit-only"
+)
+
+// mode returns the mode an incident runs in: the Mode of its rule, dryrun for
+// live rules when the executor runs with -dryrun.
+func (executor *Executor) mode(incident *lib.Incident) string {
+	switch mode := incident.Rule.Mode; {
+	case mode == confighandler.ModeAuditOnly:
+		return mode
+	case executor.dryRun || mode == confighandler.ModeDryRun:
+		return confighandler.ModeDryRun
+	}
+	return confighandler.ModeLive
+}
+
+// simulated reports whether a step is only logged instead of being run: the remediations
+// and rollbacks of incidents in dryrun mode. Audits always run for real.
+func (executor *Executor) simulated(incident *lib.Incident, step *lib.Step) bool {
+	return executor.mode(incident) == confighandler.ModeDryRun && remediationPhase(step.Phase)
+}
+
+// skipped reports whether a step is left out of the workflow, not even logged: the
+// remediations and rollbacks of incidents in audit-only mode, which only audit.
+func (executor *Executor) skipped(incident *lib.Incident, step *lib.Step) bool {
+	return executor.mode(incident) == confighandler.ModeAuditOnly && remediationPhase(step.Phase)
+}
+
+// remediationPhase reports whether the steps of phase change the devices.
+func remediationPhase(phase string) bool {
+	return phase == confighandler.PhaseRemediation || phase == confighandler.PhaseRollback
+}
+
+// simulateStep logs the exact command line and GOAR_* environment the step of result
+// would run with, and makes result successful.
+func (executor *Executor) simulateStep(incident *lib.Incident, result *Result) {
+	glog.Infof("Incident %s (%s): not running %s step %s: %q, environment %q",
+		incident.ID,
+		executor.mode(incident),
+		result.Phase,
+		result.Step,
+		result.Argv,
+		goarEnv(incident, result.Phase))
+
+	result.Simulated = true
+	result.ProcessOutput = ProcessOutput{
+		Success: true,
+		Passed:  true,
+		Result:  executor.mode(incident),
+	}
+}
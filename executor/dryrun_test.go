@@ -0,0 +1,146 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/history"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+func TestProcessIncidentNotLive(t *testing.T) {
+	const (
+		pre  = confighandler.PhasePreAudit
+		rem  = confighandler.PhaseRemediation
+		post = confighandler.PhasePostAudit
+	)
+	tests := []struct {
+		name   string
+		mode   string
+		dryRun bool
+		check  string
+		// scripts run for real, and steps in the history record, simulated ones with a * suffix
+		run         []string
+		steps       []string
+		state       string
+		transitions []string
+		reason      string
+	}{
+		{
+			name:        "dryrun logs the remediation",
+			mode:        confighandler.ModeDryRun,
+			check:       "ok.sh",
+			run:         []string{"audit", "check"},
+			steps:       []string{"audit", "fix*", "check"},
+			state:       lib.StateSimulated,
+			transitions: []string{lib.StateAuditing, lib.StateVerifying, lib.StateSimulated},
+			reason:      "dry-run, would have succeeded",
+		},
+		{
+			name:        "executor in dryrun",
+			dryRun:      true,
+			check:       "ok.sh",
+			run:         []string{"audit", "check"},
+			steps:       []string{"audit", "fix*", "check"},
+			state:       lib.StateSimulated,
+			transitions: []string{lib.StateAuditing, lib.StateVerifying, lib.StateSimulated},
+			reason:      "dry-run, would have succeeded",
+		},
+		{
+			name:        "audit-only leaves the remediation out",
+			mode:        confighandler.ModeAuditOnly,
+			check:       "ok.sh",
+			run:         []string{"audit", "check"},
+			steps:       []string{"audit", "check"},
+			state:       lib.StateSimulated,
+			transitions: []string{lib.StateAuditing, lib.StateVerifying, lib.StateSimulated},
+			reason:      "audit-only, audits passed",
+		},
+		{
+			name:        "audit-only with a failing audit",
+			mode:        confighandler.ModeAuditOnly,
+			check:       "fail.sh",
+			run:         []string{"audit", "check"},
+			steps:       []string{"audit", "check"},
+			state:       lib.StateFailed,
+			transitions: []string{lib.StateAuditing, lib.StateVerifying, lib.StateFailed},
+			reason:      "audit-only, audits did not pass: postaudit step check did not pass: not ok",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executor := newTestExecutor(t)
+			executor.SetDryRun(test.dryRun)
+			store, err := history.NewStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			executor.SetHistory(store)
+
+			fix := testStep("fix", rem, "ok.sh", "audit")
+			fix.Rollback = &lib.Command{Cmd: "ok.sh", Args: []string{"undo"}}
+			incident := &lib.Incident{
+				ID:   "42",
+				Rule: confighandler.Rule{RuleName: "interface_down", Mode: test.mode},
+				Workflow: []*lib.Step{
+					testStep("audit", pre, "ok.sh"),
+					fix,
+					testStep("check", post, test.check, "fix"),
+				},
+			}
+			incident.Transition(lib.StateDetected)
+			incident.Transition(lib.StateQueued)
+
+			acknowledger := &fakeAcknowledger{}
+			if err := executor.processIncident(context.Background(), incident, &amqp.Delivery{Acknowledger: acknowledger}); err != nil {
+				t.Fatalf("processIncident: %s", err)
+			}
+			if !acknowledger.acked {
+				t.Errorf("incident not acked")
+			}
+			if run := runLog(t, executor); !reflect.DeepEqual(run, test.run) {
+				t.Errorf("scripts run for %v, want %v", run, test.run)
+			}
+
+			var transitions []string
+			for _, transition := range incident.Transitions[2:] {
+				transitions = append(transitions, transition.State)
+			}
+			if incident.State != test.state || !reflect.DeepEqual(transitions, test.transitions) {
+				t.Errorf("incident %s after %v, want %s after %v", incident.State, transitions, test.state, test.transitions)
+			}
+
+			records, err := store.Query(history.Filter{Status: lib.EventDryRun})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("%d dryrun record(s), want 1", len(records))
+			}
+			var steps []string
+			for _, step := range records[0].Steps {
+				name := step.Name
+				if step.Simulated {
+					name += "*"
+				}
+				steps = append(steps, name)
+			}
+			if !reflect.DeepEqual(steps, test.steps) || records[0].Reason != test.reason {
+				t.Errorf("record of %v with reason %q, want %v with %q", steps, records[0].Reason, test.steps, test.reason)
+			}
+		})
+	}
+}
@@ -17,6 +17,8 @@ import (
 type InputEndpoint struct {
 	DeliveryChannel <-chan amqp.Delivery
 	AmqpQueue       amqp.Queue
+	// Prefetch is the number of unacked incidents delivered at once, 1 if not set.
+	Prefetch int
 	endpoints.RabbitMQEndpoint
 }
 
@@ -28,10 +30,51 @@ func (endpoint *InputEndpoint) Connect(conf confighandler.Config) error {
 		return err
 	}
 
+	// declared here so that dead-lettered and quarantined incidents are kept even before anyone consumes them
+	if _, err = endpoint.RabbitMQEndpoint.Channel.QueueDeclare(
+		conf.QueueDeadLetter, // name
+		true,                 // durable
+		false,                // delete when unused
+		false,                // exclusive
+		false,                // no-wait
+		nil,                  // arguments
+	); err != nil {
+		return err
+	}
+
+	if _, err = endpoint.RabbitMQEndpoint.Channel.QueueDeclare(
+		conf.QueueQuarantine, // name
+		true,                 // durable
+		false,                // delete when unused
+		false,                // exclusive
+		false,                // no-wait
+		nil,                  // arguments
+	); err != nil {
+		return err
+	}
+
+	if conf.ResultsExchange != "" {
+		if err = endpoint.RabbitMQEndpoint.Channel.ExchangeDeclare(
+			conf.ResultsExchange, // name
+			"topic",              // type
+			true,                 // durable
+			false,                // auto-deleted
+			false,                // internal
+			false,                // no-wait
+			nil,                  // arguments
+		); err != nil {
+			return err
+		}
+	}
+
+	prefetch := endpoint.Prefetch
+	if prefetch < 1 {
+		prefetch = 1
+	}
 	if err = endpoint.RabbitMQEndpoint.Channel.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
+		prefetch, // prefetch count
+		0,        // prefetch size
+		false,    // global
 	); err != nil {
 		return err
 	}
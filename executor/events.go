@@ -0,0 +1,109 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// newEvent returns an event of eventType about incident, published by this executor.
+func (executor *Executor) newEvent(incident *lib.Incident, eventType string) *lib.Event {
+	return &lib.Event{
+		Type:       eventType,
+		Time:       time.Now(),
+		Executor:   executor.hostname,
+		IncidentID: incident.ID,
+		Rule:       incident.Rule.RuleName,
+		Parameters: incident.Parameters,
+		Device:     deviceKey(incident),
+		// copied, the executor keeps adding transitions
+		State:       incident.State,
+		Transitions: append([]lib.Transition(nil), incident.Transitions...),
+		Context:     copyContext(incident.Context),
+	}
+}
+
+// copyContext returns a copy of the context of an incident, nil if it is empty.
+func copyContext(incidentContext map[string]interface{}) map[string]interface{} {
+	if len(incidentContext) == 0 {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(incidentContext))
+	for key, value := range incidentContext {
+		copied[key] = value
+	}
+	return copied
+}
+
+// emit records event in the history (see record) and publishes it to the results exchange
+// with <type>.<rule> as routing key, if RESULTS_EXCHANGE is set, the breakers getting it back
+// from there with the events of the other executors. Otherwise it feeds the breakers directly.
+// Publishing events is best effort: failing to publish one is logged and does not change
+// how the incident is handled.
+func (executor *Executor) emit(event *lib.Event) {
+	executor.record(event)
+	if executor.resultsExchange == "" {
+		// the breakers only see the events of this executor
+		executor.observe(event)
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		glog.Errorf("Incident %s: cannot marshal %s event: %s", event.IncidentID, event.Type, err)
+		return
+	}
+	if err := executor.channel.Publish(
+		executor.resultsExchange,  // exchange
+		event.Type+"."+event.Rule, // routing key
+		false,                     // mandatory
+		false,                     // immediate
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    event.Time,
+			Body:         body,
+		}); err != nil {
+		glog.Warningf("Incident %s: cannot publish %s event: %s", event.IncidentID, event.Type, err)
+	}
+}
+
+// emitStep publishes the result of a step of incident.
+func (executor *Executor) emitStep(incident *lib.Incident, result *Result) {
+	event := executor.newEvent(incident, lib.EventStep)
+	event.Step = stepResult(result)
+	executor.emit(event)
+}
+
+// stepResult converts result for the events.
+func stepResult(result *Result) *lib.StepResult {
+	step := &lib.StepResult{
+		Name:      result.Step,
+		Phase:     result.Phase,
+		Script:    result.Script,
+		Argv:      result.Argv,
+		Simulated: result.Simulated,
+		Passed:    result.ExitCode == tOK && result.ProcessOutput.Passed,
+		ExitCode:  int(result.ExitCode),
+		Stderr:    string(result.ChildStdErr),
+		Started:   result.Started,
+		Duration:  result.Duration,
+	}
+	if result.Err != nil {
+		step.Error = result.Err.Error()
+	}
+	if result.ExitCode == tOK {
+		step.Output, _ = json.Marshal(result.ProcessOutput)
+	}
+	return step
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+func TestNewEvent(t *testing.T) {
+	executor := NewExecutor("")
+	executor.hostname = "executor1"
+	detected := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	incident := &lib.Incident{
+		ID:         "42",
+		Rule:       confighandler.Rule{RuleName: "interface_down", LockKeys: []string{"hostname", "interface"}},
+		Parameters: map[string]string{"hostname": "r1", "interface": "et1", "description": "uplink"},
+		State:      lib.StateAuditing,
+		Transitions: []lib.Transition{
+			{State: lib.StateDetected, At: detected},
+			{State: lib.StateQueued, At: detected.Add(time.Second)},
+			{State: lib.StateAuditing, At: detected.Add(2 * time.Second)},
+		},
+		Context: map[string]interface{}{"alternate_path": "r1-r3"},
+	}
+
+	before := time.Now()
+	event := executor.newEvent(incident, lib.EventStep)
+	if event.Type != lib.EventStep || event.Executor != "executor1" || event.IncidentID != "42" || event.Rule != "interface_down" {
+		t.Errorf("event %s of incident %s of %s by %s, want step of 42 of interface_down by executor1",
+			event.Type, event.IncidentID, event.Rule, event.Executor)
+	}
+	if event.Time.Before(before) || event.Time.After(time.Now()) {
+		t.Errorf("event time %s, want now", event.Time)
+	}
+	if event.Device != "hostname=r1,interface=et1" {
+		t.Errorf("Device = %q, want the LockKeys values", event.Device)
+	}
+	if !reflect.DeepEqual(event.Parameters, incident.Parameters) || event.State != lib.StateAuditing ||
+		!reflect.DeepEqual(event.Transitions, incident.Transitions) {
+		t.Errorf("event parameters %v, state %s, transitions %v, want the ones of the incident", event.Parameters, event.State, event.Transitions)
+	}
+	if event.Attempt != 0 || event.Step != nil {
+		t.Errorf("event attempt %d, step %+v, left to the callers", event.Attempt, event.Step)
+	}
+
+	// the executor goes on with the incident once the event is emitted
+	incident.Transition(lib.StateRemediating)
+	incident.Context["alternate_path"] = "r1-r4"
+	if len(event.Transitions) != 3 || event.Context["alternate_path"] != "r1-r3" {
+		t.Errorf("event transitions %v and context %v changed with the incident", event.Transitions, event.Context)
+	}
+}
+
+func TestStepResult(t *testing.T) {
+	started := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	output := ProcessOutput{Success: true, Passed: true, Result: "drained", Data: map[string]interface{}{"path": "r1-r3"}}
+	tests := []struct {
+		name   string
+		result Result
+		want   lib.StepResult
+	}{
+		{
+			name: "passed",
+			result: Result{
+				Step: "drain", Phase: confighandler.PhaseRemediation, Script: "drain.py", Argv: []string{"/remediations/drain.py", "--hostname=r1"},
+				Started: started, Duration: time.Second, ChildStdErr: []byte("draining r1\n"), ProcessOutput: output,
+			},
+			want: lib.StepResult{
+				Name: "drain", Phase: confighandler.PhaseRemediation, Script: "drain.py", Argv: []string{"/remediations/drain.py", "--hostname=r1"},
+				Passed: true, Stderr: "draining r1\n", Started: started, Duration: time.Second,
+				Output: json.RawMessage(`{"success":true,"passed":true,"result":"drained","data":{"path":"r1-r3"}}`),
+			},
+		},
+		{
+			name:   "did not pass",
+			result: Result{Step: "check", Phase: confighandler.PhasePostAudit, ProcessOutput: ProcessOutput{Success: true, Result: "still down"}},
+			want: lib.StepResult{
+				Name: "check", Phase: confighandler.PhasePostAudit,
+				Output: json.RawMessage(`{"success":true,"passed":false,"result":"still down","data":null}`),
+			},
+		},
+		{
+			name:   "timed out",
+			result: Result{Step: "drain", Phase: confighandler.PhaseRemediation, ExitCode: tTimeout, Err: errors.New("timed out after 20s"), ChildStdErr: []byte("connecting\n")},
+			want: lib.StepResult{
+				Name: "drain", Phase: confighandler.PhaseRemediation,
+				ExitCode: int(tTimeout), Error: "timed out after 20s", Stderr: "connecting\n",
+			},
+		},
+		{
+			name:   "simulated",
+			result: Result{Step: "drain", Phase: confighandler.PhaseRemediation, Simulated: true, ProcessOutput: ProcessOutput{Success: true, Passed: true, Result: "dryrun"}},
+			want: lib.StepResult{
+				Name: "drain", Phase: confighandler.PhaseRemediation, Simulated: true, Passed: true,
+				Output: json.RawMessage(`{"success":true,"passed":true,"result":"dryrun","data":null}`),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if step := stepResult(&test.result); !reflect.DeepEqual(*step, test.want) {
+				t.Errorf("stepResult = %+v (output %s)\nwant %+v (output %s)", *step, step.Output, test.want, test.want.Output)
+			}
+		})
+	}
+}
+
+func TestPublishedEvents(t *testing.T) {
+	channel := &fakeChannel{published: make(map[string]amqp.Publishing)}
+	executor := NewExecutor("")
+	executor.channel = channel
+	executor.resultsExchange = "goar_results"
+
+	// an incident without steps, on its second attempt
+	incident := &lib.Incident{ID: "42", Rule: confighandler.Rule{RuleName: "interface_down"}, Parameters: map[string]string{"hostname": "r1"}}
+	incident.Transition(lib.StateDetected)
+	incident.Transition(lib.StateQueued)
+	job := &amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Headers: amqp.Table{attemptHeader: int32(2)}}
+	if err := executor.processIncident(context.Background(), incident, job); err != nil {
+		t.Fatalf("processIncident: %s", err)
+	}
+
+	for _, want := range []struct {
+		key     string
+		state   string
+		attempt int
+	}{
+		{"started.interface_down", lib.StateQueued, 2},
+		{"succeeded.interface_down", lib.StateResolved, 0},
+	} {
+		message, ok := channel.published[want.key]
+		if !ok {
+			t.Errorf("no event published with key %s, got %v", want.key, channel.published)
+			continue
+		}
+		var event lib.Event
+		if err := json.Unmarshal(message.Body, &event); err != nil {
+			t.Fatalf("event %s: %s", message.Body, err)
+		}
+		if message.ContentType != "application/json" || !message.Timestamp.Equal(event.Time) {
+			t.Errorf("%s published as %s at %s, want application/json at the event time %s", want.key, message.ContentType, message.Timestamp, event.Time)
+		}
+		if event.IncidentID != "42" || event.State != want.state || event.Attempt != want.attempt || event.Device != "hostname=r1" {
+			t.Errorf("%s event %s", want.key, message.Body)
+		}
+		if last := event.Transitions[len(event.Transitions)-1]; last.State != want.state {
+			t.Errorf("%s event transitions end with %s, want %s", want.key, last.State, want.state)
+		}
+	}
+}
@@ -9,203 +9,367 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"io/ioutil"
-	"os/exec"
+	"fmt"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
 
+	"github.com/facebookexperimental/GOAR/approvals"
 	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/history"
 	"github.com/facebookexperimental/GOAR/lib"
 	"github.com/streadway/amqp"
 )
 
-// RemediationTimeout defines maximum number of seconds an audit or remediation can run
+// RemediationTimeout defines maximum number of seconds an audit or remediation can run,
+// unless a Timeout is set on its step, its rule or the executor (see stepTimeout).
 const RemediationTimeout = time.Second * 60
 
+// Number of incidents processed concurrently by default.
+const defaultWorkers = 10
+
 // Executor defines the Input endpoint and the location of the remediation / audits.
 type Executor struct {
 	InputEndpoint    InputEndpoint
 	remediationsPath string
+	workers          int
+	// devices serializes the incidents of each device, locker excludes
+	// other executors acting on the device.
+	devices        *busyDevices
+	locker         DeviceLocker
+	lockTTL        time.Duration
+	lockRetryDelay time.Duration
+
+	// channel publishes retries and dead letters, queue being the incident
+	// queue retries go back to.
+	channel         amqpChannel
+	queue           string
+	deadLetterQueue string
+	quarantineQueue string
+	// breakers quarantine the incidents of failing rules and scripts, nil if disabled.
+	breakers *breakers
+	// retryQueues already declared, by name.
+	retryQueuesMutex sync.Mutex
+	retryQueues      map[string]bool
+	// defaultRetry applies to the rules without a retry policy.
+	defaultRetry confighandler.RetryPolicy
+	// timeout of the scripts whose step and rule have none.
+	timeout time.Duration
+	// dryRun runs every live rule in dryrun mode.
+	dryRun bool
+	// approvals holds the incidents waiting for approval, nil if not set.
+	approvals       *approvals.Store
+	approvalTimeout time.Duration
+	approvalNotify  string
+	// resultsExchange receives the incident events, none are published if empty.
+	// hostname identifies the executor in the events.
+	resultsExchange string
+	hostname        string
+	// history records what the executor did, nil if not set, with the records
+	// of the incidents in progress by incident ID.
+	history      *history.Store
+	recordsMutex sync.Mutex
+	records      map[string]*history.Record
 }
 
 // NewExecutor is Executor's constructor function
 func NewExecutor(remediationsPath string) *Executor {
+	hostname, err := os.Hostname()
+	if err != nil {
+		glog.Warningf("Cannot get the hostname: %s", err)
+	}
 	return &Executor{
 		remediationsPath: remediationsPath,
+		workers:          defaultWorkers,
+		timeout:          RemediationTimeout,
+		devices:          newBusyDevices(),
+		locker:           NewMemoryLocker(),
+		lockTTL:          defaultLockTTL,
+		lockRetryDelay:   defaultLockRetryDelay,
+		approvalTimeout:  defaultApprovalTimeout,
+		retryQueues:      make(map[string]bool),
+		records:          make(map[string]*history.Record),
+		hostname:         hostname,
 	}
 }
 
-// Run starts listening on the input channel and processes incoming incidents, one by one.
-// This is a blocking method.
-func (executor *Executor) Run() {
-	for job := range executor.InputEndpoint.DeliveryChannel {
-		var incident lib.Incident
-		if err := json.Unmarshal(job.Body, &incident); err != nil {
-			glog.Errorf("Error decomposing job. discarding: %s\n", err)
-			continue
-		}
-		executor.processIncident(&incident, &job)
-	}
-}
-
-// Connect establishes the connection to remote queue endpoint and deals with all the possible
-// failures during that phase
-func (executor *Executor) Connect(conf confighandler.Config) error {
-	return executor.InputEndpoint.Connect(conf)
+// SetDryRun makes the executor only log the remediations of the rules in live mode,
+// as if they were in dryrun mode, to watch what the rules would do.
+func (executor *Executor) SetDryRun(dryRun bool) {
+	executor.dryRun = dryRun
 }
 
-// processIncident processes singular incident - it will concurently run all the pre checks,
-// and if all of them success withing defined timeout
-// the main execution will be called.
-// After that, again, concurrently, post audits will be called.
-func (executor *Executor) processIncident(incident *lib.Incident, job *amqp.Delivery) error {
-	if executor.processPreAudits(incident) == false {
-		glog.Warning("Preaudits failed, not continuing")
-		job.Nack(false, true) // ack single job, but requeue
-		return nil
-	}
-
-	if executor.processRemediation(incident) == false {
-		glog.Warning("Remediation failed, not continuing")
-		job.Nack(false, true) // ack single job, but requeue
-		return nil
+// SetTimeout sets the timeout of the scripts whose step and rule have none, ignoring zero.
+func (executor *Executor) SetTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		executor.timeout = timeout
 	}
+}
 
-	if executor.processPostAudits(incident) == false {
-		glog.Warning("Postaudits failed, not continuing")
-		job.Nack(false, true) // ack single job, but requeue
-		return nil
+// SetDeviceLocker sets where the device leases are taken, with their TTL and the
+// delay before retrying incidents of a locked device (defaults are kept for zero values).
+func (executor *Executor) SetDeviceLocker(locker DeviceLocker, ttl time.Duration, retryDelay time.Duration) {
+	executor.locker = locker
+	if ttl > 0 {
+		executor.lockTTL = ttl
 	}
-
-	if err := job.Ack(false); err != nil {
-		glog.Warningf("Cannot ack job from the queue, %s\n", err)
-		return err
+	if retryDelay > 0 {
+		executor.lockRetryDelay = retryDelay
 	}
-	return nil
 }
 
-// processPreAudits executes in parallel multiple 3-rd party scripts configured
-// in the PreAudit argument of the processed Incident.
-func (executor *Executor) processPreAudits(incident *lib.Incident) bool {
-	return executor.execute(incident.PreAudits)
+// SetWorkers sets the number of incidents processed concurrently, ignoring values below 1.
+func (executor *Executor) SetWorkers(workers int) {
+	if workers > 0 {
+		executor.workers = workers
+	}
 }
 
-// processRemediation executes actual remediation.
-func (executor *Executor) processRemediation(incident *lib.Incident) bool {
-	return executor.execute(incident.Remediations)
+// SetBreakerPolicy sets when the rules and scripts failing too often are not run anymore.
+func (executor *Executor) SetBreakerPolicy(policy confighandler.BreakerPolicy) {
+	executor.breakers = newBreakers(policy)
 }
 
-// processPostAudits executes in parallel multiple 3-rd party scripts configured
-// in the PostAudit argument of the processed Incident.
-func (executor *Executor) processPostAudits(incident *lib.Incident) bool {
-	return executor.execute(incident.PostAudits)
+// SetRetryPolicy sets the retry policy of the rules not defining one.
+func (executor *Executor) SetRetryPolicy(policy confighandler.RetryPolicy) {
+	executor.defaultRetry = policy
 }
 
-// execute takes care of the concurrent execution of underlying commands.
-// For each command it will run a goroutine that spawns a separate process and runs binary
-// (+args) from a lib.Command object.
-// Upon the timout all processes will be killed.
-// As a result a bool value is returned , true on success and false otherwise.
-func (executor *Executor) execute(commands []*lib.Command) bool {
-
-	results := make(chan *Result)
+// Run starts listening on the input channel and processes incoming incidents with a pool
+// of workers. Incidents of different devices (see deviceKey) are processed concurrently.
+// An incident of a device with an incident in progress is delayed by lockRetryDelay, like
+// the incidents of devices locked by other executors: holding it would keep one of the
+// prefetched deliveries, which are as many as workers, from the other devices.
+// This is a blocking method.
+func (executor *Executor) Run() {
+	tasks := make(chan *task)
 	var wg sync.WaitGroup
-	wg.Add(len(commands))
+	wg.Add(executor.workers)
 
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+	for i := 0; i < executor.workers; i++ {
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				if executor.mode(task.incident) != confighandler.ModeLive {
+					// nothing changes on the device, no need to keep live incidents off it
+					executor.processIncident(context.Background(), task.incident, task.job)
+				} else {
+					executor.lockAndProcess(task)
+				}
+				executor.devices.release(task.device)
+			}
+		}()
+	}
 
-	ctx, ctxCancel := context.WithTimeout(context.Background(), RemediationTimeout)
-	defer ctxCancel()
+	for job := range executor.InputEndpoint.DeliveryChannel {
+		job := job
+		var incident lib.Incident
+		if err := json.Unmarshal(job.Body, &incident); err != nil {
+			glog.Errorf("Error decomposing job, dead-lettering: %s\n", err)
+			executor.discard(&job, fmt.Sprintf("malformed incident: %s", err))
+			continue
+		}
+		if incident.ID == "" {
+			incident.ID = job.MessageId
+		}
+		if incident.ID == "" {
+			incident.ID = lib.NewIncidentID()
+		}
+		if incident.CreatedAt.IsZero() {
+			incident.CreatedAt = job.Timestamp
+		}
 
-	for _, command := range commands {
+		if incident.FollowUpOf != "" {
+			executor.reportFollowUp(&incident, &job)
+			continue
+		}
 
-		go func(ctx context.Context, command *lib.Command, results chan *Result) {
-			defer wg.Done()
+		task := &task{incident: &incident, job: &job, device: deviceKey(&incident)}
+		if !executor.devices.acquire(task.device) {
+			executor.delay(&incident, &job, executor.lockRetryDelay, fmt.Sprintf("device %s busy with another incident", task.device))
+			continue
+		}
+		tasks <- task
+	}
+	close(tasks)
+	wg.Wait()
+}
 
-			result := &Result{
-				ExitCode: tOK,
-				Err:      nil,
-			}
+// Connect establishes the connection to remote queue endpoint and deals with all the possible
+// failures during that phase
+func (executor *Executor) Connect(conf confighandler.Config) error {
+	// enough unacked incidents for every worker to be busy
+	executor.InputEndpoint.Prefetch = executor.workers
+	if err := executor.InputEndpoint.Connect(conf); err != nil {
+		return err
+	}
+	executor.channel = executor.InputEndpoint.Channel
+	executor.queue = conf.QueueIncident
+	executor.deadLetterQueue = conf.QueueDeadLetter
+	executor.quarantineQueue = conf.QueueQuarantine
+	executor.resultsExchange = conf.ResultsExchange
+	if executor.breakers != nil && executor.resultsExchange != "" {
+		// every executor sees the failures of the whole fleet
+		return executor.watchBreakers(executor.InputEndpoint.Connection)
+	}
+	return nil
+}
 
-			cmd := exec.CommandContext(ctx, executor.remediationsPath+command.Cmd, command.Args)
+// lockAndProcess processes the incident of a task holding the lease of its device,
+// renewed until the incident is processed. If the device is locked by another executor
+// the incident is delayed by lockRetryDelay. If the lease is lost meanwhile, the running
+// steps are killed and the incident is dead-lettered: another executor may be acting on
+// the device, it is not safe to retry or roll back.
+func (executor *Executor) lockAndProcess(task *task) {
+	incident, job := task.incident, task.job
+	lease, err := executor.locker.TryLock(task.device, executor.lockTTL)
+	if err != nil {
+		if err != ErrLocked {
+			glog.Errorf("Incident %s: cannot lock device %s: %s", incident.ID, task.device, err)
+		}
+		executor.delay(incident, job, executor.lockRetryDelay, fmt.Sprintf("device %s: %s", task.device, err))
+		return
+	}
+	incident.LockToken = lease.Token
 
-			stdout, err := cmd.StdoutPipe()
-			if err != nil {
-				result.ExitCode = tConfigErr
-				result.Err = err
-				results <- result
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(lease.TTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
 				return
+			case <-ticker.C:
+				if err := executor.locker.Renew(lease); err != nil {
+					glog.Errorf("Incident %s: %s on device %s, stopping", incident.ID, err, task.device)
+					ctxCancel()
+					return
+				}
 			}
+		}
+	}()
 
-			stderr, err := cmd.StderrPipe()
-			if err != nil {
-				result.ExitCode = tConfigErr
-				result.Err = err
-				results <- result
-				return
-			}
+	executor.processIncident(ctx, incident, job)
+	ctxCancel()
+	<-renewed
 
-			if err := cmd.Start(); err != nil {
-				result.ExitCode = tConfigErr
-				result.Err = err
-				results <- result
-				return
-			}
+	if err := executor.locker.Unlock(lease); err != nil {
+		glog.Warningf("Incident %s: cannot unlock device %s: %s", incident.ID, task.device, err)
+	}
+}
 
-			if err := json.NewDecoder(stdout).Decode(&result.ProcessOutput); err != nil {
-				result.ExitCode = tOutputErr
-				result.Err = err
-				results <- result
-				return
-			}
+// processIncident processes singular incident - it runs the steps of its workflow
+// (see lib.Incident.WorkflowSteps): by default it will concurently run all the pre checks,
+// and if all of them success withing defined timeout the main execution will be called.
+// After that, again, concurrently, post audits will be called.
+// When a step fails the remediations that succeeded are rolled back. If there was nothing
+// to roll back the incident is retried according to the rule retry policy (see retryOrDeadLetter),
+// if a rollback failed it goes to the dead-letter queue, as do the incidents whose ctx was canceled.
+// Live incidents of a rule or script whose breaker is open are quarantined instead (see breakers).
+func (executor *Executor) processIncident(ctx context.Context, incident *lib.Incident, job *amqp.Delivery) error {
+	if lib.Final(incident.State) {
+		return executor.deadLetter(incident, job, "incident already "+incident.State)
+	}
+	// e.g. incidents replayed from the dead-letter queue while awaiting approval
+	transition(incident, lib.StateQueued)
 
-			if buffer, err := ioutil.ReadAll(stderr); err != nil {
-				result.ExitCode = tExecErr
-				result.Err = err
-				results <- result
-			} else {
-				result.ChildStdErr = buffer
-			}
+	steps := incident.WorkflowSteps()
+	if executor.breakers != nil && executor.mode(incident) == confighandler.ModeLive {
+		if breaker := executor.breakers.allow(incidentBreakers(incident, steps), time.Now()); breaker != "" {
+			return executor.quarantine(incident, job, breaker)
+		}
+	}
 
-			if err := cmd.Wait(); err != nil {
-				result.ExitCode = tOutputErr
-				result.Err = err
-				results <- result
-				return
-			}
+	started := executor.newEvent(incident, lib.EventStarted)
+	started.Attempt = jobAttempt(job)
+	executor.emit(started)
 
-			results <- result
-		}(ctx, command, results)
+	if incident.Rule.RequiresApproval && incident.Approval == nil && executor.mode(incident) == confighandler.ModeLive {
+		return executor.requestApproval(ctx, incident, job, steps)
 	}
 
-	// wait for the results
-
-	for result := range results {
-		if result.ExitCode != tOK {
-			glog.Warningf("Error executing process: %s, exit code %v \n", result.Err, result.ExitCode)
-			ctxCancel()
-			return false
+	run := executor.runWorkflow(ctx, incident, steps)
+	if mode := executor.mode(incident); mode != confighandler.ModeLive {
+		// there is nothing to roll back or retry, the logs are the point
+		outcome, succeeded, stopped := outcomeDryRun, "would have succeeded", "would have stopped"
+		if mode == confighandler.ModeAuditOnly {
+			outcome, succeeded, stopped = outcomeAuditOnly, "audits passed", "audits did not pass"
 		}
-
-		if result.ProcessOutput.Passed == false {
-			glog.Warning("Process succeded but operation failed")
-			ctxCancel()
-			return false
+		state, reason := lib.StateSimulated, outcome+", "+succeeded
+		if run.Failed != nil {
+			state, reason = lib.StateFailed, outcome+", "+stopped+": "+failureReason(run)
+			glog.Warningf("Incident %s: %s", incident.ID, reason)
+		} else {
+			glog.Infof("Incident %s: %s", incident.ID, reason)
 		}
+		transition(incident, state)
+		event := executor.newEvent(incident, lib.EventDryRun)
+		event.Reason = reason
+		executor.emit(event)
+		return job.Ack(false)
+	}
+	if run.Failed != nil && ctx.Err() != nil {
+		return executor.deadLetter(incident, job, failureReason(run)+", device lease lost")
+	}
+	if run.Failed != nil {
+		glog.Warningf("Incident %s: %s step %s failed, not continuing", incident.ID, run.Failed.Phase, run.Failed.Name)
+		executor.rollback(incident, run)
+	}
 
-		glog.Infof("Processed incident: Exit code %d\nSuccess %v\nPassed %v\nResult %s\nStderr %v\n",
-			result.ExitCode,
-			result.ProcessOutput.Success,
-			result.ProcessOutput.Passed,
-			result.ProcessOutput.Result,
-			string(result.ChildStdErr))
+	switch run.Outcome {
+	case outcomeFailed:
+		return executor.retryOrDeadLetter(incident, job, run)
+	case outcomeRollbackFailed:
+		// running the remediations again on top of a partial rollback could make things worse
+		return executor.deadLetter(incident, job, failureReason(run)+", rollback failed, manual intervention needed")
+	case outcomeRolledBack:
+		glog.Warningf("Incident %s rolled back after %d rollback(s)", incident.ID, len(run.Rollbacks))
+		transition(incident, lib.StateRolledBack)
+		event := executor.newEvent(incident, lib.EventRolledBack)
+		event.Reason = failureReason(run)
+		executor.emit(event)
+	case outcomeSucceeded:
+		transition(incident, lib.StateResolved)
+		executor.emit(executor.newEvent(incident, lib.EventSucceeded))
+	}
 
+	if err := job.Ack(false); err != nil {
+		glog.Warningf("Cannot ack job from the queue, %s\n", err)
+		return err
 	}
-	return true
+	return nil
+}
 
+// reportFollowUp publishes the suppressed event of the incident a follow-up reports on,
+// and acks it: follow-ups run nothing, there is no device to lock.
+func (executor *Executor) reportFollowUp(followUp *lib.Incident, job *amqp.Delivery) error {
+	glog.Infof("Incident %s: %d identical incident(s) suppressed", followUp.FollowUpOf, followUp.SuppressedCount)
+	event := executor.newEvent(followUp, lib.EventSuppressed)
+	// the state of the incident is not known here
+	event.IncidentID, event.State, event.Transitions = followUp.FollowUpOf, "", nil
+	event.SuppressedCount = followUp.SuppressedCount
+	executor.emit(event)
+	return job.Ack(false)
+}
+
+// mergeContext adds the data returned by a step to the incident context.
+// Keys already set by previous steps are overwritten.
+func mergeContext(incident *lib.Incident, result *Result) {
+	if len(result.ProcessOutput.Data) == 0 {
+		return
+	}
+	if incident.Context == nil {
+		incident.Context = make(map[string]interface{}, len(result.ProcessOutput.Data))
+	}
+	for key, value := range result.ProcessOutput.Data {
+		if previous, ok := incident.Context[key]; ok {
+			glog.V(1).Infof("Step %s overwrites %s in incident %s context, was %v", result.Step, key, incident.ID, previous)
+		}
+		incident.Context[key] = value
+	}
 }
@@ -0,0 +1,80 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/history"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// fakeAcknowledger records how a job was acknowledged.
+type fakeAcknowledger struct {
+	acked, nacked, requeued bool
+}
+
+func (acknowledger *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	acknowledger.acked = true
+	return nil
+}
+
+func (acknowledger *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	acknowledger.nacked, acknowledger.requeued = true, requeue
+	return nil
+}
+
+func (acknowledger *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return acknowledger.Nack(tag, false, requeue)
+}
+
+func TestReportFollowUp(t *testing.T) {
+	store, err := history.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor := NewExecutor("")
+	executor.SetHistory(store)
+
+	rule := confighandler.Rule{RuleName: "cpu"}
+	incident := &lib.Incident{ID: "first", Rule: rule, Parameters: map[string]string{"hostname": "r1"}}
+	followUp := &lib.Incident{ID: "follow-up", Rule: rule, Parameters: incident.Parameters, FollowUpOf: "first", SuppressedCount: 3}
+
+	// the follow-up arrives while the first incident is still being handled
+	executor.emit(executor.newEvent(incident, lib.EventStarted))
+	acknowledger := &fakeAcknowledger{}
+	if err := executor.reportFollowUp(followUp, &amqp.Delivery{Acknowledger: acknowledger}); err != nil {
+		t.Fatalf("reportFollowUp: %s", err)
+	}
+	if !acknowledger.acked {
+		t.Errorf("follow-up not acked")
+	}
+	executor.emit(executor.newEvent(incident, lib.EventSucceeded))
+
+	records, err := store.Query(history.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, record := range records {
+		if record.IncidentID != "first" {
+			t.Errorf("record %s of incident %s, want the first incident", record.Status, record.IncidentID)
+		}
+		if record.Status == lib.EventSuppressed && record.Reason != "3 identical incident(s) suppressed" {
+			t.Errorf("suppressed record reason = %q", record.Reason)
+		}
+		statuses = append(statuses, record.Status)
+	}
+	sort.Strings(statuses)
+	if len(statuses) != 2 || statuses[0] != lib.EventSucceeded || statuses[1] != lib.EventSuppressed {
+		t.Errorf("records = %v, want the handling of the first incident, succeeded, and the suppressed one", statuses)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/history"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// SetHistory sets the store recording what the executor did, nil to record nothing.
+func (executor *Executor) SetHistory(store *history.Store) {
+	executor.history = store
+}
+
+// record adds event to the history record of its incident. A record starts with the
+// started event, collects the step events and is written to the history store with
+// the next event, which ends the handling of the incident by the executor. A started
+// record is also written right away, so that a handling that never ends, e.g. because
+// the executor crashed, still leaves a trace. The approval events, happening outside
+// of the processing of the incident, get a record of their own.
+func (executor *Executor) record(event *lib.Event) {
+	switch {
+	case executor.history == nil:
+		return
+	case event.Type == lib.EventBreakerOpened || event.Type == lib.EventBreakerClosed || event.Type == lib.EventBreakerReset:
+		// about a rule or a script, not the incident
+		return
+	case event.Type == lib.EventSuppressed:
+		// the incident may still be handled, the follow-up is recorded apart
+		record := newRecord(event)
+		record.Status, record.Finished = event.Type, event.Time
+		record.Reason = fmt.Sprintf("%d identical incident(s) suppressed", event.SuppressedCount)
+		if err := executor.history.Add(record); err != nil {
+			glog.Errorf("Incident %s: cannot add %s record to the history: %s", record.IncidentID, record.Status, err)
+		}
+		return
+	}
+
+	executor.recordsMutex.Lock()
+	record, ok := executor.records[event.IncidentID]
+	switch {
+	case event.Type == lib.EventStarted:
+		record = newRecord(event)
+		executor.records[event.IncidentID] = record
+		started := *record
+		executor.recordsMutex.Unlock()
+
+		started.Status = event.Type
+		if err := executor.history.Add(&started); err != nil {
+			glog.Errorf("Incident %s: cannot add %s record to the history: %s", started.IncidentID, started.Status, err)
+		}
+		return
+	case event.Type == lib.EventStep:
+		if ok {
+			record.Steps = append(record.Steps, event.Step)
+		}
+		executor.recordsMutex.Unlock()
+		return
+	case ok:
+		delete(executor.records, event.IncidentID)
+	default:
+		record = newRecord(event)
+	}
+	executor.recordsMutex.Unlock()
+
+	record.Status = event.Type
+	record.Reason = event.Reason
+	record.By = event.By
+	record.Finished = event.Time
+	record.State = event.State
+	record.Transitions = event.Transitions
+	record.Context = event.Context
+	if err := executor.history.Add(record); err != nil {
+		glog.Errorf("Incident %s: cannot add %s record to the history: %s", record.IncidentID, record.Status, err)
+	}
+}
+
+// newRecord returns the history record of the incident of event, starting with it.
+func newRecord(event *lib.Event) *history.Record {
+	return &history.Record{
+		IncidentID: event.IncidentID,
+		Rule:       event.Rule,
+		Device:     event.Device,
+		Parameters: event.Parameters,
+		Executor:   event.Executor,
+		Attempt:    event.Attempt,
+		Started:    event.Time,
+		Context:    event.Context,
+	}
+}
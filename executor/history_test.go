@@ -0,0 +1,53 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/history"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+func TestRecordContext(t *testing.T) {
+	store, err := history.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor := NewExecutor("")
+	executor.SetHistory(store)
+	incident := &lib.Incident{ID: "42", Rule: confighandler.Rule{RuleName: "interface_down"}, Parameters: map[string]string{"hostname": "r1"}}
+
+	started := executor.newEvent(incident, lib.EventStarted)
+	if started.Context != nil {
+		t.Errorf("started event context = %v, want none before any step", started.Context)
+	}
+	executor.emit(started)
+	incident.Context = map[string]interface{}{"alternate_path": "r1-r3"}
+	succeeded := executor.newEvent(incident, lib.EventSucceeded)
+	// the event keeps the context it was created with
+	incident.Context["alternate_path"] = "r1-r4"
+	executor.emit(succeeded)
+
+	records, err := store.Query(history.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contexts := make(map[string]map[string]interface{})
+	for _, record := range records {
+		contexts[record.Status] = record.Context
+	}
+	// the started record is hidden once the handling ended
+	want := map[string]map[string]interface{}{
+		lib.EventSucceeded: {"alternate_path": "r1-r3"},
+	}
+	if !reflect.DeepEqual(contexts, want) {
+		t.Errorf("record contexts = %v, want %v", contexts, want)
+	}
+}
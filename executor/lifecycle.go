@@ -0,0 +1,41 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// phaseStates maps the phase of the steps to the state of the incidents running them.
+var phaseStates = map[string]string{
+	confighandler.PhasePreAudit:    lib.StateAuditing,
+	confighandler.PhaseRemediation: lib.StateRemediating,
+	confighandler.PhasePostAudit:   lib.StateVerifying,
+}
+
+// transition moves incident to state, logging the transitions the lifecycle does not allow.
+func transition(incident *lib.Incident, state string) {
+	if err := incident.Transition(state); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// startPhase moves incident to the state of the steps of phase when it starts one.
+// Workflows can run the steps of several phases concurrently, or an audit after a
+// remediation: the incident stays in the furthest state reached.
+func startPhase(incident *lib.Incident, phase string) {
+	state, ok := phaseStates[phase]
+	if !ok {
+		return
+	}
+	if err := incident.Transition(state); err != nil {
+		glog.V(1).Infof("Incident %s stays %s starting a %s step", incident.ID, incident.State, phase)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+)
+
+// Default lease settings, see Config.LockTTL and Config.LockRetryDelay.
+const (
+	defaultLockTTL        = time.Minute
+	defaultLockRetryDelay = 30 * time.Second
+)
+
+// ErrLocked is returned by DeviceLocker.TryLock when another holder has the lease of the device.
+var ErrLocked = errors.New("device locked")
+
+// ErrLeaseLost is returned by DeviceLocker.Renew when the lease expired or was taken over.
+var ErrLeaseLost = errors.New("device lease lost")
+
+// Lease is the exclusive right to act on a device until Expires, unless renewed.
+type Lease struct {
+	Device string
+	// Token is the fencing token of the lease: it increases every time the lease of
+	// the device is granted, so whatever acts on the device can reject a holder
+	// older than the last one it has seen.
+	Token   uint64
+	Expires time.Time
+	TTL     time.Duration
+
+	// channel holding the lock queue of a RabbitMQ lease.
+	channel *amqp.Channel
+}
+
+// DeviceLocker grants leases on devices, so that a single executor acts on a device at a time.
+type DeviceLocker interface {
+	// TryLock returns a lease on device valid for ttl, or ErrLocked if another holder has one.
+	TryLock(device string, ttl time.Duration) (*Lease, error)
+	// Renew extends the lease for its TTL, or returns ErrLeaseLost if it already expired.
+	Renew(lease *Lease) error
+	// Unlock releases the lease.
+	Unlock(lease *Lease) error
+}
+
+// NewDeviceLocker returns the DeviceLocker set as DEVICE_LOCKER in the config: the
+// RabbitMQ one, shared by all executors, or the memory one (default), which only
+// excludes the incidents of a single executor.
+func NewDeviceLocker(conf confighandler.Config) (DeviceLocker, error) {
+	switch conf.DeviceLocker {
+	case "", "memory":
+		return NewMemoryLocker(), nil
+	case "rabbitmq":
+		return NewRabbitMQLocker(conf)
+	}
+	return nil, fmt.Errorf("unknown DEVICE_LOCKER %s", conf.DeviceLocker)
+}
+
+// MemoryLocker is a DeviceLocker for a single process.
+type MemoryLocker struct {
+	mutex  sync.Mutex
+	leases map[string]*Lease
+	// tokens holds the last token granted per device.
+	tokens map[string]uint64
+}
+
+// NewMemoryLocker is MemoryLocker's constructor function
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
+		leases: make(map[string]*Lease),
+		tokens: make(map[string]uint64),
+	}
+}
+
+// TryLock implements DeviceLocker.
+func (locker *MemoryLocker) TryLock(device string, ttl time.Duration) (*Lease, error) {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	if current, ok := locker.leases[device]; ok && time.Now().Before(current.Expires) {
+		return nil, ErrLocked
+	}
+	locker.tokens[device]++
+	lease := &Lease{
+		Device:  device,
+		Token:   locker.tokens[device],
+		Expires: time.Now().Add(ttl),
+		TTL:     ttl,
+	}
+	locker.leases[device] = lease
+	return lease, nil
+}
+
+// Renew implements DeviceLocker.
+func (locker *MemoryLocker) Renew(lease *Lease) error {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	if locker.leases[lease.Device] != lease || time.Now().After(lease.Expires) {
+		return ErrLeaseLost
+	}
+	lease.Expires = time.Now().Add(lease.TTL)
+	return nil
+}
+
+// Unlock implements DeviceLocker.
+func (locker *MemoryLocker) Unlock(lease *Lease) error {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	if locker.leases[lease.Device] == lease {
+		delete(locker.leases, lease.Device)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/endpoints"
+)
+
+// Prefix of the queues used as device locks.
+const lockQueuePrefix = "goar.lock."
+
+// RabbitMQLocker is a DeviceLocker shared by every executor connected to the same broker.
+//
+// The lease of a device is an exclusive queue, goar.lock.<device>: RabbitMQ lets a
+// single connection declare it, and deletes it when that connection closes, or once
+// it has not been used (renewed) for the TTL of the lease (x-expires).
+// Fencing tokens are kept as the single message of the goar.lock.<device>.fence
+// queue, only read and replaced by the holder of the lease.
+type RabbitMQLocker struct {
+	endpoint endpoints.RabbitMQEndpoint
+}
+
+// NewRabbitMQLocker connects to the broker with a connection dedicated to the locks.
+func NewRabbitMQLocker(conf confighandler.Config) (*RabbitMQLocker, error) {
+	locker := &RabbitMQLocker{}
+	if err := locker.endpoint.Connect(conf); err != nil {
+		return nil, err
+	}
+	// the endpoint channel is not used, every lease gets its own as a failed
+	// declaration closes the channel
+	return locker, nil
+}
+
+// TryLock implements DeviceLocker.
+func (locker *RabbitMQLocker) TryLock(device string, ttl time.Duration) (*Lease, error) {
+	channel, err := locker.endpoint.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &Lease{Device: device, TTL: ttl, channel: channel}
+	if err := declareLockQueue(lease); err != nil {
+		channel.Close()
+		if amqpErr, ok := err.(*amqp.Error); ok && amqpErr.Code == amqp.ResourceLocked {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	lease.Expires = time.Now().Add(ttl)
+
+	if lease.Token, err = nextFencingToken(channel, device); err != nil {
+		locker.Unlock(lease)
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Renew implements DeviceLocker. Getting from the lock queue resets its expiration,
+// and fails if it was deleted.
+func (locker *RabbitMQLocker) Renew(lease *Lease) error {
+	if _, _, err := lease.channel.Get(lockQueuePrefix+lease.Device, true); err != nil {
+		glog.Warningf("Cannot renew the lease of device %s: %s", lease.Device, err)
+		return ErrLeaseLost
+	}
+	lease.Expires = time.Now().Add(lease.TTL)
+	return nil
+}
+
+// Unlock implements DeviceLocker.
+func (locker *RabbitMQLocker) Unlock(lease *Lease) error {
+	defer lease.channel.Close()
+	_, err := lease.channel.QueueDelete(
+		lockQueuePrefix+lease.Device, // name
+		false,                        // if unused
+		false,                        // if empty
+		false,                        // no-wait
+	)
+	return err
+}
+
+// declareLockQueue declares the exclusive lock queue of the device of lease.
+func declareLockQueue(lease *Lease) error {
+	_, err := lease.channel.QueueDeclare(
+		lockQueuePrefix+lease.Device, // name
+		false,                        // durable
+		false,                        // delete when unused
+		true,                         // exclusive
+		false,                        // no-wait
+		amqp.Table{ // arguments
+			"x-expires": int64(lease.TTL / time.Millisecond),
+		},
+	)
+	return err
+}
+
+// nextFencingToken increments and returns the fencing token of device. All the messages
+// of the fence queue are read in case a previous holder died while replacing the token.
+func nextFencingToken(channel *amqp.Channel, device string) (uint64, error) {
+	name := lockQueuePrefix + device + ".fence"
+	if _, err := channel.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	); err != nil {
+		return 0, err
+	}
+
+	var token uint64
+	var last *amqp.Delivery
+	for {
+		message, ok, err := channel.Get(name, false)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+		if previous, err := strconv.ParseUint(string(message.Body), 10, 64); err == nil && previous > token {
+			token = previous
+		}
+		last = &message
+	}
+	token++
+
+	if err := channel.Publish(
+		"",    // exchange
+		name,  // routing key
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			ContentType:  "text/plain",
+			DeliveryMode: amqp.Persistent,
+			Body:         []byte(strconv.FormatUint(token, 10)),
+		}); err != nil {
+		return 0, err
+	}
+	if last != nil {
+		return token, last.Ack(true)
+	}
+	return token, nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testLeaseTTL is the TTL of the test leases.
+const testLeaseTTL = 100 * time.Millisecond
+
+func TestMemoryLocker(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, locker *MemoryLocker)
+	}{
+		{
+			name: "locked until unlocked",
+			run: func(t *testing.T, locker *MemoryLocker) {
+				lease := mustLock(t, locker, "r1")
+				if _, err := locker.TryLock("r1", testLeaseTTL); err != ErrLocked {
+					t.Fatalf("second TryLock error = %v, want ErrLocked", err)
+				}
+				if _, err := locker.TryLock("r2", testLeaseTTL); err != nil {
+					t.Fatalf("TryLock of another device: %s", err)
+				}
+				locker.Unlock(lease)
+				mustLock(t, locker, "r1")
+			},
+		},
+		{
+			name: "expired lease taken over",
+			run: func(t *testing.T, locker *MemoryLocker) {
+				lease := mustLock(t, locker, "r1")
+				time.Sleep(2 * testLeaseTTL)
+				mustLock(t, locker, "r1")
+				if err := locker.Renew(lease); err != ErrLeaseLost {
+					t.Fatalf("Renew of the expired lease error = %v, want ErrLeaseLost", err)
+				}
+			},
+		},
+		{
+			name: "expired lease not renewed",
+			run: func(t *testing.T, locker *MemoryLocker) {
+				lease := mustLock(t, locker, "r1")
+				time.Sleep(2 * testLeaseTTL)
+				if err := locker.Renew(lease); err != ErrLeaseLost {
+					t.Fatalf("Renew error = %v, want ErrLeaseLost", err)
+				}
+			},
+		},
+		{
+			name: "renewal keeps the lease",
+			run: func(t *testing.T, locker *MemoryLocker) {
+				lease := mustLock(t, locker, "r1")
+				for i := 0; i < 4; i++ {
+					time.Sleep(testLeaseTTL / 2)
+					expires := lease.Expires
+					if err := locker.Renew(lease); err != nil {
+						t.Fatalf("Renew %d: %s", i, err)
+					}
+					if !lease.Expires.After(expires) {
+						t.Fatalf("Renew %d did not extend the lease", i)
+					}
+				}
+				if _, err := locker.TryLock("r1", testLeaseTTL); err != ErrLocked {
+					t.Fatalf("TryLock of the renewed lease error = %v, want ErrLocked", err)
+				}
+			},
+		},
+		{
+			name: "unlocked lease not renewed",
+			run: func(t *testing.T, locker *MemoryLocker) {
+				lease := mustLock(t, locker, "r1")
+				locker.Unlock(lease)
+				if err := locker.Renew(lease); err != ErrLeaseLost {
+					t.Fatalf("Renew error = %v, want ErrLeaseLost", err)
+				}
+			},
+		},
+		{
+			name: "stale unlock keeps the new lease",
+			run: func(t *testing.T, locker *MemoryLocker) {
+				stale := mustLock(t, locker, "r1")
+				time.Sleep(2 * testLeaseTTL)
+				lease := mustLock(t, locker, "r1")
+				locker.Unlock(stale)
+				if _, err := locker.TryLock("r1", testLeaseTTL); err != ErrLocked {
+					t.Fatalf("TryLock error = %v, want ErrLocked", err)
+				}
+				if err := locker.Renew(lease); err != nil {
+					t.Fatalf("Renew of the new lease: %s", err)
+				}
+			},
+		},
+		{
+			name: "fencing tokens increase per device",
+			run: func(t *testing.T, locker *MemoryLocker) {
+				var tokens []uint64
+				for i := 0; i < 3; i++ {
+					lease := mustLock(t, locker, "r1")
+					tokens = append(tokens, lease.Token)
+					locker.Unlock(lease)
+				}
+				expired := mustLock(t, locker, "r1")
+				time.Sleep(2 * testLeaseTTL)
+				tokens = append(tokens, expired.Token, mustLock(t, locker, "r1").Token)
+				for i := 1; i < len(tokens); i++ {
+					if tokens[i] <= tokens[i-1] {
+						t.Fatalf("tokens %v do not increase", tokens)
+					}
+				}
+				if token := mustLock(t, locker, "r2").Token; token != 1 {
+					t.Fatalf("first token of another device = %d, want 1", token)
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, NewMemoryLocker())
+		})
+	}
+}
+
+// mustLock returns a lease on device valid for testLeaseTTL, failing the test if it is locked.
+func mustLock(t *testing.T, locker *MemoryLocker, device string) *Lease {
+	t.Helper()
+	lease, err := locker.TryLock(device, testLeaseTTL)
+	if err != nil {
+		t.Fatalf("TryLock(%s): %s", device, err)
+	}
+	return lease
+}
@@ -9,7 +9,9 @@ package main
 import (
 	"flag"
 
+	"github.com/facebookexperimental/GOAR/approvals"
 	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/history"
 	"github.com/golang/glog"
 )
 
@@ -17,6 +19,8 @@ var remediationsPath = flag.String("remediations",
 	"../remediations/",
 	"Path to the directory with your remediations scripts")
 var configPath = flag.String("config", "../config.yaml", "Path to the configuration file")
+var approvalsListen = flag.String("approvals_listen", "", "Address the approvals API listens on (e.g. localhost:8090), disabled if empty")
+var dryRun = flag.Bool("dryrun", false, "Only log the remediations of live rules, running their audits, as if they were in dryrun mode")
 
 func main() {
 	flag.Parse()
@@ -27,11 +31,45 @@ func main() {
 		glog.Exitf("Error while reading config file: %s\n", err)
 	}
 	engine := NewExecutor(*remediationsPath)
+	engine.SetRetryPolicy(conf.Retry)
+	engine.SetBreakerPolicy(conf.Breaker)
+	engine.SetWorkers(conf.ExecutorWorkers)
+	engine.SetTimeout(conf.ExecutorTimeout)
+	engine.SetDryRun(*dryRun)
+
+	locker, err := NewDeviceLocker(conf)
+	if err != nil {
+		glog.Exitf("Error while setting the device locker: %v\n", err)
+	}
+	engine.SetDeviceLocker(locker, conf.LockTTL, conf.LockRetryDelay)
+
+	if conf.ApprovalsDir != "" {
+		store, err := approvals.NewStore(conf.ApprovalsDir)
+		if err != nil {
+			glog.Exitf("Error while opening the approvals store: %v\n", err)
+		}
+		engine.SetApprovals(store, conf.ApprovalTimeout, conf.ApprovalNotify)
+	}
+
+	if conf.HistoryDir != "" {
+		store, err := history.NewStore(conf.HistoryDir)
+		if err != nil {
+			glog.Exitf("Error while opening the history store: %v\n", err)
+		}
+		engine.SetHistory(store)
+	}
 
 	if err := engine.Connect(conf); err != nil {
 		glog.Exitf("Error while establishing connection: %v\n", err)
 	}
 
+	if conf.ApprovalsDir != "" {
+		engine.WatchApprovals(approvalsPollInterval)
+		if *approvalsListen != "" {
+			engine.ServeApprovals(*approvalsListen)
+		}
+	}
+
 	glog.Info("Running executor")
 	engine.Run()
 }
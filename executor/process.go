@@ -6,6 +6,17 @@
 
 package main
 
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
 type exitCode int
 
 const (
@@ -13,8 +24,22 @@ const (
 	tConfigErr
 	tOutputErr
 	tExecErr
+	// the process did not finish within the step timeout
+	tTimeout
 )
 
+// killGracePeriod is how long a process group has to exit after SIGTERM, before SIGKILL.
+const killGracePeriod = 5 * time.Second
+
+// StepInput is written as JSON to the stdin of every audit and remediation,
+// so scripts can look at the whole incident without re-parsing syslog.
+// Phase is one of the confighandler.Phase* constants.
+type StepInput struct {
+	Phase    string        `json:"phase"`
+	Step     string        `json:"step"`
+	Incident *lib.Incident `json:"incident"`
+}
+
 // ProcessOutput structures
 // output from underlying audits/remediations
 type ProcessOutput struct {
@@ -25,11 +50,26 @@ type ProcessOutput struct {
 	// Result represents arbitrary result passed from
 	// underlying audit process.
 	Result string `json:"result"`
+	// Data is structured output merged into the incident Context,
+	// where later steps find it (e.g. the alternate path validated by an audit).
+	Data map[string]interface{} `json:"data"`
 }
 
 // Result represents
 // result of executed command
 type Result struct {
+	// Step is the name of the script that produced the result.
+	Step  string
+	Phase string
+	// Script is the path of the script in the remediations directory,
+	// Argv the command line it was run with.
+	Script string
+	Argv   []string
+	// Simulated is true if the step was only logged, see simulated.
+	Simulated bool
+	// Started is when the script was started and Duration how long it ran.
+	Started  time.Time
+	Duration time.Duration
 	// ExitCode has non zero values with different values dependin on when the execution failed.
 	// Please examine exicCode type and constant values it provides.
 	ExitCode exitCode
@@ -40,3 +80,39 @@ type Result struct {
 	// Instance of well defined output - an effect of working audit or remediation.
 	ProcessOutput ProcessOutput
 }
+
+// stepEnv returns the environment of a script: the executor environment plus
+// GOAR_INCIDENT_ID, GOAR_RULE, GOAR_PHASE, GOAR_LOCK_TOKEN and a GOAR_PARAM_<NAME> variable
+// per incident parameter, the name being upper cased with non alphanumerics replaced by _.
+func stepEnv(incident *lib.Incident, phase string) []string {
+	return append(os.Environ(), goarEnv(incident, phase)...)
+}
+
+// goarEnv returns the variables stepEnv adds to the executor environment.
+func goarEnv(incident *lib.Incident, phase string) []string {
+	env := []string{
+		"GOAR_INCIDENT_ID=" + incident.ID,
+		"GOAR_RULE=" + incident.Rule.RuleName,
+		"GOAR_PHASE=" + phase,
+		"GOAR_LOCK_TOKEN=" + strconv.FormatUint(incident.LockToken, 10),
+	}
+	names := make([]string, 0, len(incident.Parameters))
+	for name := range incident.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, "GOAR_PARAM_"+envName(name)+"="+incident.Parameters[name])
+	}
+	return env
+}
+
+// envName converts a parameter name into an environment variable name.
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
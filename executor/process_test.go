@@ -0,0 +1,115 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"hostname":       "HOSTNAME",
+		"peer_interface": "PEER_INTERFACE",
+		"vlan2":          "VLAN2",
+		"bgp-peer.ip":    "BGP_PEER_IP",
+		"interface name": "INTERFACE_NAME",
+		"intérface":      "INT_RFACE",
+		"":               "",
+	}
+	for name, want := range tests {
+		if got := envName(name); got != want {
+			t.Errorf("envName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGoarEnv(t *testing.T) {
+	incident := &lib.Incident{
+		ID:        "42",
+		Rule:      confighandler.Rule{RuleName: "interface_down"},
+		LockToken: 7,
+		Parameters: map[string]string{
+			"interface":   "Ethernet1/1",
+			"hostname":    "r1",
+			"description": "uplink to r2; $(reboot)",
+		},
+	}
+	want := []string{
+		"GOAR_INCIDENT_ID=42",
+		"GOAR_RULE=interface_down",
+		"GOAR_PHASE=remediation",
+		"GOAR_LOCK_TOKEN=7",
+		"GOAR_PARAM_DESCRIPTION=uplink to r2; $(reboot)",
+		"GOAR_PARAM_HOSTNAME=r1",
+		"GOAR_PARAM_INTERFACE=Ethernet1/1",
+	}
+	if env := goarEnv(incident, confighandler.PhaseRemediation); !reflect.DeepEqual(env, want) {
+		t.Errorf("goarEnv = %q, want %q", env, want)
+	}
+
+	// stepEnv keeps the environment of the executor
+	t.Setenv("GOAR_TEST_INHERITED", "yes")
+	env := stepEnv(incident, confighandler.PhasePreAudit)
+	found := map[string]bool{}
+	for _, variable := range env {
+		found[variable] = true
+	}
+	if !found["GOAR_TEST_INHERITED=yes"] || !found["GOAR_PHASE=preaudit"] || !found["GOAR_PARAM_HOSTNAME=r1"] {
+		t.Errorf("stepEnv = %q, want the executor environment and the GOAR variables", env)
+	}
+}
+
+func TestStepInputJSON(t *testing.T) {
+	incident := &lib.Incident{ID: "42", Parameters: map[string]string{"hostname": "r1"}}
+	data, err := json.Marshal(StepInput{Phase: confighandler.PhasePostAudit, Step: "check", Incident: incident})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"phase", "step", "incident"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("step input %s has no %s field", data, name)
+		}
+	}
+	if string(fields["phase"]) != `"postaudit"` || string(fields["step"]) != `"check"` {
+		t.Errorf("step input %s, want phase postaudit and step check", data)
+	}
+}
+
+func TestStepTimeout(t *testing.T) {
+	executor := NewExecutor("")
+	executor.SetTimeout(time.Minute)
+	tests := []struct {
+		name        string
+		stepTimeout time.Duration
+		ruleTimeout time.Duration
+		want        time.Duration
+	}{
+		// set on the step or on the step of its workflow definition
+		{"step", 10 * time.Second, 20 * time.Second, 10 * time.Second},
+		{"rule", 0, 20 * time.Second, 20 * time.Second},
+		{"executor", 0, 0, time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			incident := &lib.Incident{Rule: confighandler.Rule{Timeout: test.ruleTimeout}}
+			step := &lib.Step{Name: "fix", Timeout: test.stepTimeout}
+			if timeout := executor.stepTimeout(incident, step); timeout != test.want {
+				t.Errorf("stepTimeout = %s, want %s", timeout, test.want)
+			}
+		})
+	}
+}
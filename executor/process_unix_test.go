@@ -0,0 +1,156 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// writeScript writes an executable shell script running body to dir.
+func writeScript(t *testing.T, dir string, name string, body string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStepInput(t *testing.T) {
+	dir := t.TempDir()
+	// record.sh saves its stdin and GOAR_* environment as <step>.stdin and <step>.env
+	writeScript(t, dir, "record.sh", `dir=$(dirname "$0")
+cat >"$dir/$1.stdin"
+env | grep '^GOAR_' | sort >"$dir/$1.env"
+echo '{"success": true, "passed": true, "data": {"path": "r1-r3"}}'`)
+	executor := NewExecutor(dir + "/")
+
+	incident := &lib.Incident{
+		ID:         "42",
+		Rule:       confighandler.Rule{RuleName: "interface_down"},
+		Parameters: map[string]string{"hostname": "r1", "peer-interface": "et 1"},
+	}
+	run := executor.runWorkflow(context.Background(), incident, []*lib.Step{
+		{Name: "audit", Phase: confighandler.PhasePreAudit, Command: &lib.Command{Cmd: "record.sh", Args: []string{"audit"}}},
+		{Name: "fix", Phase: confighandler.PhaseRemediation, Command: &lib.Command{Cmd: "record.sh", Args: []string{"fix"}}, DependsOn: []string{"audit"}},
+	})
+	if run.Outcome != outcomeSucceeded {
+		t.Fatalf("outcome %s, failed step %v", run.Outcome, run.Failed)
+	}
+
+	for _, step := range []struct {
+		name, phase string
+		context     map[string]interface{}
+	}{
+		{"audit", confighandler.PhasePreAudit, nil},
+		// the input of a step has the data of the steps before it
+		{"fix", confighandler.PhaseRemediation, map[string]interface{}{"path": "r1-r3"}},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, step.name+".stdin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var input StepInput
+		if err := json.Unmarshal(data, &input); err != nil {
+			t.Fatalf("stdin of %s %q: %s", step.name, data, err)
+		}
+		if input.Phase != step.phase || input.Step != step.name || input.Incident == nil || input.Incident.ID != "42" ||
+			input.Incident.Parameters["peer-interface"] != "et 1" {
+			t.Errorf("stdin of %s = %s, want its phase, name and the incident", step.name, data)
+			continue
+		}
+		if len(input.Incident.Context) != len(step.context) || (step.context != nil && input.Incident.Context["path"] != step.context["path"]) {
+			t.Errorf("stdin of %s has context %v, want %v", step.name, input.Incident.Context, step.context)
+		}
+
+		env, err := ioutil.ReadFile(filepath.Join(dir, step.name+".env"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.Join(goarEnv(incident, step.phase), "\n")
+		if got := strings.TrimSpace(string(env)); !sameLines(got, want) {
+			t.Errorf("environment of %s\n%s\nwant\n%s", step.name, got, want)
+		}
+	}
+}
+
+// sameLines reports whether a and b have the same lines, in any order.
+func sameLines(a string, b string) bool {
+	linesA, linesB := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(linesA) != len(linesB) {
+		return false
+	}
+	count := make(map[string]int, len(linesA))
+	for _, line := range linesA {
+		count[line]++
+	}
+	for _, line := range linesB {
+		if count[line]--; count[line] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStepTimeoutKillsProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	// hang.sh starts a grandchild keeping stdout open, saves its pid and never answers
+	writeScript(t, dir, "hang.sh", `sleep 60 &
+echo $! >"$(dirname "$0")/grandchild.pid"
+sleep 60`)
+	executor := NewExecutor(dir + "/")
+	incident := &lib.Incident{ID: "42", Rule: confighandler.Rule{RuleName: "interface_down", Timeout: time.Minute}}
+	step := &lib.Step{Name: "hang", Phase: confighandler.PhasePreAudit, Command: &lib.Command{Cmd: "hang.sh"}, Timeout: 200 * time.Millisecond}
+
+	start := time.Now()
+	result := executor.runStep(context.Background(), incident, step, []byte("{}"), nil)
+	// the grandchild holds the output pipe, runStep only returns once it is gone too
+	if elapsed := time.Since(start); elapsed > killGracePeriod {
+		t.Errorf("step stopped after %s, want right after its timeout", elapsed)
+	}
+	if result.ExitCode != tTimeout || result.Err == nil || result.Err.Error() != "timed out after 200ms" {
+		t.Errorf("result exit code %v, error %v, want a timeout after 200ms", result.ExitCode, result.Err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "grandchild.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// its output closes a bit before it is gone
+	for deadline := time.Now().Add(time.Second); processRunning(pid); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("grandchild %d still running once the step timed out", pid)
+		}
+	}
+}
+
+// processRunning reports whether the process pid exists and is not a zombie, from /proc:
+// always false where there is none.
+func processRunning(pid int) bool {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	// the state follows the command name, in parentheses
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
@@ -0,0 +1,271 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// AMQP headers set on retried and dead-lettered incidents.
+const (
+	// Number of the attempt the message is for, 1 when missing.
+	attemptHeader = "x-goar-attempt"
+	// Why the last attempt failed.
+	failureReasonHeader = "x-goar-failure-reason"
+)
+
+// defaultRetryPolicy applies when neither the rule nor the config define one.
+var defaultRetryPolicy = confighandler.RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     30 * time.Second,
+	MaxBackoff:  10 * time.Minute,
+}
+
+// amqpChannel is the part of *amqp.Channel used to publish retries and dead letters.
+type amqpChannel interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// retryPolicy returns the policy of the rule, the executor one if the rule has none.
+// A zero Backoff defaults to the one of defaultRetryPolicy.
+func (executor *Executor) retryPolicy(rule confighandler.Rule) confighandler.RetryPolicy {
+	policy := rule.Retry
+	if policy.MaxAttempts == 0 {
+		policy = executor.defaultRetry
+	}
+	if policy.MaxAttempts == 0 {
+		policy = defaultRetryPolicy
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaultRetryPolicy.Backoff
+	}
+	return policy
+}
+
+// retryDelay returns how long to wait before the attempt following attempt:
+// Backoff doubled for every attempt already retried, up to MaxBackoff.
+// Delays are rounded to the millisecond, the TTL unit of the retry queues.
+func retryDelay(policy confighandler.RetryPolicy, attempt int) time.Duration {
+	delay := policy.Backoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if policy.MaxBackoff > 0 && delay >= policy.MaxBackoff {
+			break
+		}
+	}
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	return delay.Round(time.Millisecond)
+}
+
+// retryable reports whether the failure of a step of phase can be retried under policy.
+func retryable(policy confighandler.RetryPolicy, phase string) bool {
+	if len(policy.Phases) == 0 {
+		return true
+	}
+	for _, retryablePhase := range policy.Phases {
+		if retryablePhase == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// jobAttempt returns the attempt a job is for, read from its attempt header.
+func jobAttempt(job *amqp.Delivery) int {
+	switch attempt := job.Headers[attemptHeader].(type) {
+	case int32:
+		return int(attempt)
+	case int64:
+		return int(attempt)
+	case int:
+		return attempt
+	}
+	return 1
+}
+
+// failureReason describes why the workflow of an incident failed.
+func failureReason(run *workflowRun) string {
+	step := run.Failed
+	result, ok := run.Results[step.Name]
+	switch {
+	case !ok:
+		return fmt.Sprintf("%s step %s never ran", step.Phase, step.Name)
+	case result.ExitCode != tOK:
+		return fmt.Sprintf("%s step %s failed: %v, exit code %v", step.Phase, step.Name, result.Err, result.ExitCode)
+	}
+	return fmt.Sprintf("%s step %s did not pass: %s", step.Phase, step.Name, result.ProcessOutput.Result)
+}
+
+// retryOrDeadLetter handles a job whose workflow failed without anything to roll back:
+// the incident is published to the retry queue matching its backoff delay, which sends
+// it back to the incident queue once the delay expired, or to the dead-letter queue if
+// it is out of attempts or the failed step phase is not retryable.
+// The job is acked once the incident is published elsewhere, requeued if that failed.
+func (executor *Executor) retryOrDeadLetter(incident *lib.Incident, job *amqp.Delivery, run *workflowRun) error {
+	policy := executor.retryPolicy(incident.Rule)
+	attempt := jobAttempt(job)
+	reason := failureReason(run)
+
+	switch {
+	case attempt >= policy.MaxAttempts:
+		return executor.deadLetter(incident, job, fmt.Sprintf("%s, after %d attempt(s)", reason, attempt))
+	case !retryable(policy, run.Failed.Phase):
+		return executor.deadLetter(incident, job, fmt.Sprintf("%s, %s failures are not retried", reason, run.Failed.Phase))
+	}
+
+	if incident.Approval != nil {
+		// approved on the output of pre-audits that ran before this attempt: the retry
+		// runs them again and waits for a new approval
+		body, err := withoutApproval(job.Body)
+		if err != nil {
+			return executor.deadLetter(incident, job, fmt.Sprintf("%s, cannot retry without the approval: %s", reason, err))
+		}
+		job.Body = body
+	}
+
+	delay := retryDelay(policy, attempt)
+	glog.Warningf("Incident %s: attempt %d/%d failed (%s), retrying in %s", incident.ID, attempt, policy.MaxAttempts, reason, delay)
+	transition(incident, lib.StateQueued)
+	event := executor.newEvent(incident, lib.EventRetried)
+	event.Attempt = attempt + 1
+	event.Delay = delay
+	event.Reason = reason
+	executor.emit(event)
+	return executor.schedule(incident, job, delay, attempt+1, reason)
+}
+
+// withoutApproval returns the body of a job with the Approval of its incident removed.
+func withoutApproval(body []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "Approval")
+	return json.Marshal(fields)
+}
+
+// delay publishes the job again after delay without counting an attempt, for incidents
+// that could not be processed yet (e.g. their device is locked), and acks it.
+func (executor *Executor) delay(incident *lib.Incident, job *amqp.Delivery, delay time.Duration, reason string) error {
+	glog.Infof("Incident %s delayed by %s: %s", incident.ID, delay, reason)
+	transition(incident, lib.StateQueued)
+	event := executor.newEvent(incident, lib.EventDelayed)
+	event.Attempt = jobAttempt(job)
+	event.Delay = delay
+	event.Reason = reason
+	executor.emit(event)
+	return executor.schedule(incident, job, delay, jobAttempt(job), reason)
+}
+
+// schedule publishes the job to the retry queue of delay, as the given attempt, and acks it.
+// The job is requeued if that fails.
+func (executor *Executor) schedule(incident *lib.Incident, job *amqp.Delivery, delay time.Duration, attempt int, reason string) error {
+	queue, err := executor.retryQueue(delay)
+	if err == nil {
+		err = executor.republish(job, queue, attempt, reason)
+	}
+	if err != nil {
+		glog.Errorf("Incident %s: cannot schedule retry, requeuing: %s", incident.ID, err)
+		return job.Nack(false, true)
+	}
+	return job.Ack(false)
+}
+
+// deadLetter publishes the job to the dead-letter queue with the failure reason and acks it.
+func (executor *Executor) deadLetter(incident *lib.Incident, job *amqp.Delivery, reason string) error {
+	if err := executor.republish(job, executor.deadLetterQueue, jobAttempt(job), reason); err != nil {
+		glog.Errorf("Incident %s: cannot dead-letter, requeuing: %s", incident.ID, err)
+		return job.Nack(false, true)
+	}
+	glog.Errorf("Incident %s sent to dead-letter queue %s: %s", incident.ID, executor.deadLetterQueue, reason)
+	transition(incident, lib.StateFailed)
+	event := executor.newEvent(incident, lib.EventFailed)
+	event.Attempt = jobAttempt(job)
+	event.Reason = reason
+	executor.emit(event)
+	return job.Ack(false)
+}
+
+// discard publishes a job that is not an incident to the dead-letter queue and acks it.
+// The job is dropped if that fails, it would never be processed anyway.
+func (executor *Executor) discard(job *amqp.Delivery, reason string) {
+	if err := executor.republish(job, executor.deadLetterQueue, jobAttempt(job), reason); err != nil {
+		glog.Errorf("Cannot dead-letter job %s, dropping it: %s", job.MessageId, err)
+		job.Nack(false, false)
+		return
+	}
+	job.Ack(false)
+}
+
+// retryQueue declares, once per delay, the queue holding incidents to retry after delay.
+// Named <incident queue>.retry.<delay in ms>, it expires messages after delay to the
+// default exchange with the incident queue as routing key.
+func (executor *Executor) retryQueue(delay time.Duration) (string, error) {
+	ms := int64(delay / time.Millisecond)
+	name := fmt.Sprintf("%s.retry.%d", executor.queue, ms)
+
+	executor.retryQueuesMutex.Lock()
+	defer executor.retryQueuesMutex.Unlock()
+	if executor.retryQueues[name] {
+		return name, nil
+	}
+
+	if _, err := executor.channel.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		amqp.Table{ // arguments
+			"x-message-ttl":             ms,
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": executor.queue,
+		},
+	); err != nil {
+		return "", err
+	}
+	executor.retryQueues[name] = true
+	return name, nil
+}
+
+// republish publishes the body of job to queue, with its headers plus the attempt and failure reason.
+func (executor *Executor) republish(job *amqp.Delivery, queue string, attempt int, reason string) error {
+	headers := make(amqp.Table, len(job.Headers)+2)
+	for key, value := range job.Headers {
+		headers[key] = value
+	}
+	headers[attemptHeader] = int32(attempt)
+	headers[failureReasonHeader] = reason
+
+	return executor.publish(queue, job.ContentType, job.Body, headers)
+}
+
+// publish publishes a persistent message to queue.
+func (executor *Executor) publish(queue string, contentType string, body []byte, headers amqp.Table) error {
+	return executor.channel.Publish(
+		"",    // exchange
+		queue, // routing key
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			ContentType:  contentType,
+			DeliveryMode: amqp.Persistent,
+			Headers:      headers,
+			Body:         body,
+		})
+}
@@ -0,0 +1,177 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := confighandler.RetryPolicy{MaxAttempts: 10, Backoff: 30 * time.Second, MaxBackoff: 10 * time.Minute}
+	tests := []struct {
+		name    string
+		policy  confighandler.RetryPolicy
+		attempt int
+		delay   time.Duration
+	}{
+		{"first retry", policy, 1, 30 * time.Second},
+		{"doubled", policy, 2, time.Minute},
+		{"doubled twice", policy, 3, 2 * time.Minute},
+		{"capped", policy, 6, 10 * time.Minute},
+		{"capped for many attempts", policy, 1000, 10 * time.Minute},
+		{"uncapped", confighandler.RetryPolicy{Backoff: time.Second}, 11, 1024 * time.Second},
+		{"rounded to the millisecond", confighandler.RetryPolicy{Backoff: 1500 * time.Microsecond}, 1, 2 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if delay := retryDelay(test.policy, test.attempt); delay != test.delay {
+				t.Errorf("retryDelay(%+v, %d) = %s, want %s", test.policy, test.attempt, delay, test.delay)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	executorPolicy := confighandler.RetryPolicy{MaxAttempts: 5, Backoff: time.Minute}
+	tests := []struct {
+		name     string
+		executor confighandler.RetryPolicy
+		rule     confighandler.RetryPolicy
+		policy   confighandler.RetryPolicy
+	}{
+		{"default", confighandler.RetryPolicy{}, confighandler.RetryPolicy{}, defaultRetryPolicy},
+		{"executor", executorPolicy, confighandler.RetryPolicy{}, executorPolicy},
+		{"rule", executorPolicy, confighandler.RetryPolicy{MaxAttempts: 2, Backoff: time.Second}, confighandler.RetryPolicy{MaxAttempts: 2, Backoff: time.Second}},
+		{"rule without backoff", executorPolicy, confighandler.RetryPolicy{MaxAttempts: 2}, confighandler.RetryPolicy{MaxAttempts: 2, Backoff: defaultRetryPolicy.Backoff}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executor := NewExecutor("")
+			executor.SetRetryPolicy(test.executor)
+			policy := executor.retryPolicy(confighandler.Rule{Retry: test.rule})
+			if policy.MaxAttempts != test.policy.MaxAttempts || policy.Backoff != test.policy.Backoff || policy.MaxBackoff != test.policy.MaxBackoff {
+				t.Errorf("retryPolicy = %+v, want %+v", policy, test.policy)
+			}
+		})
+	}
+}
+
+func TestJobAttempt(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		attempt int
+	}{
+		{"no header", nil, 1},
+		{"int32", amqp.Table{attemptHeader: int32(3)}, 3},
+		{"int64", amqp.Table{attemptHeader: int64(4)}, 4},
+		{"not a number", amqp.Table{attemptHeader: "5"}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if attempt := jobAttempt(&amqp.Delivery{Headers: test.headers}); attempt != test.attempt {
+				t.Errorf("jobAttempt = %d, want %d", attempt, test.attempt)
+			}
+		})
+	}
+}
+
+// fakeChannel records what the executor publishes.
+type fakeChannel struct {
+	declared  []string
+	published map[string]amqp.Publishing
+}
+
+func (channel *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	channel.declared = append(channel.declared, name)
+	return amqp.Queue{Name: name}, nil
+}
+
+func (channel *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	channel.published[key] = msg
+	return nil
+}
+
+func TestRetryOrDeadLetter(t *testing.T) {
+	policy := confighandler.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Second,
+		Phases:      []string{confighandler.PhasePreAudit, confighandler.PhasePostAudit},
+	}
+	tests := []struct {
+		name     string
+		attempt  int32
+		phase    string
+		approved bool
+		// queue the job is published to and the attempt it is for
+		queue      string
+		newAttempt int32
+	}{
+		{"retried", 1, confighandler.PhasePostAudit, false, "incidents.retry.1000", 2},
+		{"backoff", 2, confighandler.PhasePostAudit, false, "incidents.retry.2000", 3},
+		{"out of attempts", 3, confighandler.PhasePostAudit, false, "incidents_dead", 3},
+		{"phase not retried", 1, confighandler.PhaseRemediation, false, "incidents_dead", 1},
+		{"approval dropped", 1, confighandler.PhasePreAudit, true, "incidents.retry.1000", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			channel := &fakeChannel{published: make(map[string]amqp.Publishing)}
+			executor := NewExecutor("")
+			executor.channel = channel
+			executor.queue = "incidents"
+			executor.deadLetterQueue = "incidents_dead"
+
+			incident := &lib.Incident{ID: "test", State: lib.StateRemediating, Rule: confighandler.Rule{RuleName: "rule", Retry: policy}}
+			if test.approved {
+				incident.Approval = &lib.Approval{By: "alice"}
+			}
+			body, err := json.Marshal(incident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			acknowledger := &fakeAcknowledger{}
+			job := &amqp.Delivery{
+				Acknowledger: acknowledger,
+				Headers:      amqp.Table{attemptHeader: test.attempt},
+				Body:         body,
+			}
+			step := &lib.Step{Name: "step", Phase: test.phase}
+			run := &workflowRun{Failed: step, Results: map[string]*Result{step.Name: {Step: step.Name, ExitCode: tOK}}}
+
+			if err := executor.retryOrDeadLetter(incident, job, run); err != nil {
+				t.Fatal(err)
+			}
+			if !acknowledger.acked || acknowledger.nacked {
+				t.Errorf("job acked %t, nacked %t, want acked", acknowledger.acked, acknowledger.nacked)
+			}
+			message, ok := channel.published[test.queue]
+			if !ok || len(channel.published) != 1 {
+				t.Fatalf("published to %v, want %s", channel.published, test.queue)
+			}
+			if attempt := message.Headers[attemptHeader]; attempt != test.newAttempt {
+				t.Errorf("attempt header = %v, want %d", attempt, test.newAttempt)
+			}
+			if reason, _ := message.Headers[failureReasonHeader].(string); reason == "" {
+				t.Error("no failure reason header")
+			}
+			var published lib.Incident
+			if err := json.Unmarshal(message.Body, &published); err != nil {
+				t.Fatal(err)
+			}
+			if published.Approval != nil {
+				t.Errorf("published incident approved by %s", published.Approval.By)
+			}
+		})
+	}
+}
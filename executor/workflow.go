@@ -0,0 +1,373 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// Outcomes of the workflow of an incident.
+const (
+	outcomeSucceeded = "succeeded"
+	// A step failed and nothing was rolled back.
+	outcomeFailed = "failed"
+	// A step failed and the remediations that succeeded were rolled back.
+	outcomeRolledBack = "rolled back"
+	// A step failed and so did one of the rollbacks, the device is in an unknown state.
+	outcomeRollbackFailed = "rollback failed"
+)
+
+// workflowRun describes the outcome of running the steps of an incident.
+type workflowRun struct {
+	// Outcome is one of the outcome* constants.
+	Outcome string
+	// Results of the steps executed, by step name.
+	Results map[string]*Result
+	// Completed lists the steps that succeeded, in completion order.
+	Completed []*lib.Step
+	// Skipped lists the steps whose When condition was false.
+	Skipped []*lib.Step
+	// Failed is the step that failed the workflow, nil on success.
+	Failed *lib.Step
+	// Rollbacks are the results of the rollbacks executed, in execution order.
+	Rollbacks []*Result
+}
+
+// runWorkflow schedules the steps of an incident. A step starts once all the steps it
+// depends on are done, so steps whose dependencies are met run concurrently, each in
+// its own process (see runStep). A step whose When condition is false is skipped and
+// still counts as done for the steps depending on it.
+// The first failing step, or the cancellation of ctx, stops the workflow: no other
+// step is started and the running ones are killed.
+func (executor *Executor) runWorkflow(ctx context.Context, incident *lib.Incident, steps []*lib.Step) *workflowRun {
+	run := &workflowRun{Results: make(map[string]*Result, len(steps))}
+
+	ctx, ctxCancel := context.WithCancel(ctx)
+	defer ctxCancel()
+
+	results := make(chan *Result)
+	pending := append([]*lib.Step(nil), steps...)
+	byName := make(map[string]*lib.Step, len(steps))
+	done := make(map[string]bool, len(steps))
+	running := 0
+
+	for _, step := range steps {
+		byName[step.Name] = step
+	}
+	if incident.Approval != nil {
+		pending = approvedSteps(incident, pending, run, done)
+	}
+
+	for {
+		// start every step whose dependencies are done, skipping a step
+		// can unlock others so keep going until nothing changes
+		for progress := run.Failed == nil; progress; {
+			progress = false
+			remaining := pending[:0]
+			for _, step := range pending {
+				if !dependenciesDone(step, done) {
+					remaining = append(remaining, step)
+					continue
+				}
+				progress = true
+
+				if executor.skipped(incident, step) {
+					glog.Infof("Incident %s (%s): leaving out %s step %s", incident.ID, executor.mode(incident), step.Phase, step.Name)
+					done[step.Name] = true
+					continue
+				}
+				if !conditionMet(step.When, run.Results) {
+					glog.Infof("Incident %s: skipping step %s, condition on %s not met", incident.ID, step.Name, step.When.Step)
+					run.Skipped = append(run.Skipped, step)
+					done[step.Name] = true
+					continue
+				}
+
+				if !executor.simulated(incident, step) {
+					// a logged remediation changes nothing, the incident is not remediating
+					startPhase(incident, step.Phase)
+				}
+				// Marshaled before starting the step, the context is updated as results arrive.
+				input, err := json.Marshal(StepInput{Phase: step.Phase, Step: step.Name, Incident: incident})
+				running++
+				go func(step *lib.Step) {
+					results <- executor.runStep(ctx, incident, step, input, err)
+				}(step)
+			}
+			pending = remaining
+		}
+
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		step := byName[result.Step]
+		run.Results[step.Name] = result
+		executor.emitStep(incident, result)
+
+		if result.ExitCode != tOK {
+			glog.Warningf("Error executing process %s: %s, exit code %v \n", step.Name, result.Err, result.ExitCode)
+		} else if result.ProcessOutput.Passed == false {
+			glog.Warningf("Process %s succeded but operation failed", step.Name)
+		} else {
+			glog.Infof("Processed incident: Step %s\nExit code %d\nSuccess %v\nPassed %v\nResult %s\nStderr %v\n",
+				step.Name,
+				result.ExitCode,
+				result.ProcessOutput.Success,
+				result.ProcessOutput.Passed,
+				result.ProcessOutput.Result,
+				string(result.ChildStdErr))
+
+			mergeContext(incident, result)
+			run.Completed = append(run.Completed, step)
+			done[step.Name] = true
+			continue
+		}
+
+		if run.Failed == nil {
+			run.Failed = step
+			ctxCancel()
+		}
+	}
+
+	if run.Failed == nil && len(pending) > 0 {
+		// only possible with dependencies on unknown steps or cycles
+		run.Failed = pending[0]
+		glog.Errorf("Incident %s: step %s can never run, its dependencies %v are not satisfiable",
+			incident.ID, run.Failed.Name, run.Failed.DependsOn)
+	}
+	run.Outcome = outcomeSucceeded
+	if run.Failed != nil {
+		run.Outcome = outcomeFailed
+	}
+	glog.Infof("Incident %s context: %v", incident.ID, incident.Context)
+	return run
+}
+
+// rollback runs the rollbacks of the remediation steps of a failed run that succeeded,
+// one at a time and in reverse completion order. It stops at the first failing rollback:
+// undoing earlier remediations on a device in an unknown state is not safe.
+func (executor *Executor) rollback(incident *lib.Incident, run *workflowRun) {
+	for i := len(run.Completed) - 1; i >= 0; i-- {
+		step := run.Completed[i]
+		if step.Phase != confighandler.PhaseRemediation || step.Rollback == nil {
+			continue
+		}
+
+		rollbackStep := &lib.Step{
+			Name:    step.Name + "/rollback",
+			Phase:   confighandler.PhaseRollback,
+			Command: step.Rollback,
+		}
+		input, err := json.Marshal(StepInput{Phase: rollbackStep.Phase, Step: rollbackStep.Name, Incident: incident})
+		result := executor.runStep(context.Background(), incident, rollbackStep, input, err)
+		run.Rollbacks = append(run.Rollbacks, result)
+		executor.emitStep(incident, result)
+
+		if result.ExitCode != tOK || result.ProcessOutput.Passed == false {
+			glog.Errorf("Incident %s: rollback of step %s failed: %v, exit code %v, result %s, stderr %s",
+				incident.ID, step.Name, result.Err, result.ExitCode, result.ProcessOutput.Result, result.ChildStdErr)
+			run.Outcome = outcomeRollbackFailed
+			return
+		}
+		glog.Infof("Incident %s: rolled back step %s: %s", incident.ID, step.Name, result.ProcessOutput.Result)
+		mergeContext(incident, result)
+		run.Outcome = outcomeRolledBack
+	}
+}
+
+// runStep spawns a separate process running the binary (+args) of a step.
+// The process receives the incident as JSON on stdin (see StepInput) and
+// its identifiers in the environment (see stepEnv).
+// Upon the timout (see stepTimeout) or cancellation of ctx the process group
+// of the process will be stopped (see stopOnDone).
+func (executor *Executor) runStep(ctx context.Context, incident *lib.Incident, step *lib.Step, input []byte, inputErr error) *Result {
+	result := &Result{
+		Step:     step.Name,
+		Phase:    step.Phase,
+		Script:   step.Command.Cmd,
+		Argv:     append([]string{executor.remediationsPath + step.Command.Cmd}, step.Command.Args...),
+		Started:  time.Now(),
+		ExitCode: tOK,
+		Err:      nil,
+	}
+	defer func() {
+		result.Duration = time.Since(result.Started)
+	}()
+	if inputErr != nil {
+		result.ExitCode = tConfigErr
+		result.Err = inputErr
+		return result
+	}
+	if executor.simulated(incident, step) {
+		executor.simulateStep(incident, result)
+		return result
+	}
+
+	timeout := executor.stepTimeout(incident, step)
+	ctx, ctxCancel := context.WithTimeout(ctx, timeout)
+	defer ctxCancel()
+
+	cmd := exec.Command(executor.remediationsPath+step.Command.Cmd, step.Command.Args...)
+	cmd.Env = stepEnv(incident, step.Phase)
+	cmd.Stdin = bytes.NewReader(input)
+	setProcessGroup(cmd)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		result.ExitCode = tConfigErr
+		result.Err = err
+		return result
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		result.ExitCode = tConfigErr
+		result.Err = err
+		return result
+	}
+
+	if err := cmd.Start(); err != nil {
+		result.ExitCode = tConfigErr
+		result.Err = err
+		return result
+	}
+
+	exited := make(chan struct{})
+	stopped := make(chan bool, 1)
+	go func() {
+		stopped <- stopOnDone(ctx, cmd, exited)
+	}()
+
+	collectOutput(cmd, stdout, stderr, result)
+	close(exited)
+
+	if <-stopped && ctx.Err() == context.DeadlineExceeded {
+		result.ExitCode = tTimeout
+		result.Err = fmt.Errorf("timed out after %s", timeout)
+	}
+	return result
+}
+
+// collectOutput reads the output of a started process into result and waits for it to exit.
+func collectOutput(cmd *exec.Cmd, stdout io.Reader, stderr io.Reader, result *Result) {
+	if err := json.NewDecoder(stdout).Decode(&result.ProcessOutput); err != nil {
+		result.ExitCode = tOutputErr
+		result.Err = err
+		cmd.Wait()
+		return
+	}
+
+	buffer, err := ioutil.ReadAll(stderr)
+	if err != nil {
+		result.ExitCode = tExecErr
+		result.Err = err
+		cmd.Wait()
+		return
+	}
+	result.ChildStdErr = buffer
+
+	if err := cmd.Wait(); err != nil {
+		result.ExitCode = tOutputErr
+		result.Err = err
+	}
+}
+
+// stopOnDone stops the process group of cmd once ctx is done, unless exited is closed
+// first: the group gets SIGTERM, then SIGKILL if the process has not exited after
+// killGracePeriod. Grandchildren keeping the output pipes open are killed the same way.
+// It returns whether the process group was signaled.
+func stopOnDone(ctx context.Context, cmd *exec.Cmd, exited chan struct{}) bool {
+	select {
+	case <-exited:
+		return false
+	case <-ctx.Done():
+	}
+
+	if err := terminateProcessGroup(cmd); err != nil {
+		glog.Warningf("Cannot terminate process %s: %s", cmd.Path, err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(killGracePeriod):
+		glog.Warningf("Process %s still running %s after SIGTERM, killing it", cmd.Path, killGracePeriod)
+		if err := killProcessGroup(cmd); err != nil {
+			glog.Warningf("Cannot kill process %s: %s", cmd.Path, err)
+		}
+	}
+	return true
+}
+
+// stepTimeout returns the timeout of a step: the one of the step, or of the
+// incident rule, or of the executor.
+func (executor *Executor) stepTimeout(incident *lib.Incident, step *lib.Step) time.Duration {
+	switch {
+	case step.Timeout > 0:
+		return step.Timeout
+	case incident.Rule.Timeout > 0:
+		return incident.Rule.Timeout
+	}
+	return executor.timeout
+}
+
+// dependenciesDone reports whether all the steps a step depends on are done.
+func dependenciesDone(step *lib.Step, done map[string]bool) bool {
+	for _, dependency := range step.DependsOn {
+		if !done[dependency] {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionMet evaluates the When condition of a step against the results
+// of the steps already executed. A nil condition is always met.
+func conditionMet(condition *confighandler.StepCondition, results map[string]*Result) bool {
+	if condition == nil {
+		return true
+	}
+	result, ok := results[condition.Step]
+	if !ok {
+		// skipped step
+		return false
+	}
+
+	expected := condition.Equals
+	if expected == "" {
+		expected = "true"
+	}
+
+	var value interface{}
+	switch key := condition.Key; {
+	case key == "result":
+		value = result.ProcessOutput.Result
+	case strings.HasPrefix(key, "data."):
+		var found bool
+		if value, found = result.ProcessOutput.Data[strings.TrimPrefix(key, "data.")]; !found {
+			return false
+		}
+	default:
+		glog.Errorf("Unknown key %s in condition on step %s", key, condition.Step)
+		return false
+	}
+	return fmt.Sprint(value) == expected
+}
@@ -0,0 +1,316 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// testScripts are the remediation scripts of the tests. They log their first argument,
+// the name of the step, to run.log before printing their output.
+var testScripts = map[string]string{
+	"ok.sh":   `{"success": true, "passed": true, "result": "ok"}`,
+	"fail.sh": `{"success": true, "passed": false, "result": "not ok"}`,
+	"gone.sh": `{"success": true, "passed": true, "result": "ok", "data": {"process": "gone"}}`,
+}
+
+// newTestExecutor returns an executor running testScripts from a temporary directory.
+func newTestExecutor(t *testing.T) *Executor {
+	t.Helper()
+	dir := t.TempDir()
+	for name, output := range testScripts {
+		writeScript(t, dir, name, "cat >/dev/null\necho \"$1\" >>\"$(dirname \"$0\")/run.log\"\necho '"+output+"'")
+	}
+	return NewExecutor(dir + "/")
+}
+
+// runLog returns the steps run by the scripts of executor, in order.
+func runLog(t *testing.T, executor *Executor) []string {
+	t.Helper()
+	log, err := ioutil.ReadFile(executor.remediationsPath + "run.log")
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(log))
+}
+
+// testStep returns a step named name running script, depending on dependsOn.
+func testStep(name string, phase string, script string, dependsOn ...string) *lib.Step {
+	return &lib.Step{
+		Name:      name,
+		Phase:     phase,
+		Command:   &lib.Command{Cmd: script, Args: []string{name}},
+		DependsOn: dependsOn,
+	}
+}
+
+// stepNames returns the names of steps.
+func stepNames(steps []*lib.Step) []string {
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+func TestRunWorkflow(t *testing.T) {
+	const (
+		pre  = confighandler.PhasePreAudit
+		rem  = confighandler.PhaseRemediation
+		post = confighandler.PhasePostAudit
+	)
+	when := func(step *lib.Step, condition confighandler.StepCondition) *lib.Step {
+		step.When = &condition
+		return step
+	}
+
+	tests := []struct {
+		name    string
+		steps   []*lib.Step
+		outcome string
+		failed  string
+		// completed and skipped steps, in order when there is a single possible one
+		completed []string
+		skipped   []string
+		// not run at all
+		notRun []string
+	}{
+		{
+			name: "sequence",
+			steps: []*lib.Step{
+				testStep("audit", pre, "ok.sh"),
+				testStep("fix", rem, "ok.sh", "audit"),
+				testStep("check", post, "ok.sh", "fix"),
+			},
+			outcome:   outcomeSucceeded,
+			completed: []string{"audit", "fix", "check"},
+		},
+		{
+			name: "declared out of order",
+			steps: []*lib.Step{
+				testStep("check", post, "ok.sh", "fix"),
+				testStep("fix", rem, "ok.sh", "audit"),
+				testStep("audit", pre, "ok.sh"),
+			},
+			outcome:   outcomeSucceeded,
+			completed: []string{"audit", "fix", "check"},
+		},
+		{
+			name: "failing step stops the workflow",
+			steps: []*lib.Step{
+				testStep("audit", pre, "ok.sh"),
+				testStep("fix", rem, "fail.sh", "audit"),
+				testStep("check", post, "ok.sh", "fix"),
+			},
+			outcome:   outcomeFailed,
+			failed:    "fix",
+			completed: []string{"audit"},
+			notRun:    []string{"check"},
+		},
+		{
+			name: "condition met",
+			steps: []*lib.Step{
+				testStep("kill", rem, "gone.sh"),
+				when(testStep("check", post, "ok.sh", "kill"), confighandler.StepCondition{Step: "kill", Key: "data.process", Equals: "gone"}),
+			},
+			outcome:   outcomeSucceeded,
+			completed: []string{"kill", "check"},
+		},
+		{
+			name: "condition not met",
+			steps: []*lib.Step{
+				testStep("kill", rem, "gone.sh"),
+				when(testStep("restart", rem, "ok.sh", "kill"), confighandler.StepCondition{Step: "kill", Key: "data.process", Equals: "running"}),
+				testStep("check", post, "ok.sh", "restart"),
+			},
+			outcome:   outcomeSucceeded,
+			completed: []string{"kill", "check"},
+			skipped:   []string{"restart"},
+			notRun:    []string{"restart"},
+		},
+		{
+			name: "condition on a skipped step",
+			steps: []*lib.Step{
+				when(testStep("kill", rem, "ok.sh"), confighandler.StepCondition{Step: "missing", Key: "result", Equals: "ok"}),
+				when(testStep("check", post, "ok.sh", "kill"), confighandler.StepCondition{Step: "kill", Key: "result", Equals: "ok"}),
+			},
+			outcome: outcomeSucceeded,
+			skipped: []string{"kill", "check"},
+			notRun:  []string{"kill", "check"},
+		},
+		{
+			name: "unknown dependency",
+			steps: []*lib.Step{
+				testStep("audit", pre, "ok.sh"),
+				testStep("fix", rem, "ok.sh", "audit", "missing"),
+			},
+			outcome:   outcomeFailed,
+			failed:    "fix",
+			completed: []string{"audit"},
+			notRun:    []string{"fix"},
+		},
+		{
+			name: "cycle",
+			steps: []*lib.Step{
+				testStep("a", rem, "ok.sh", "b"),
+				testStep("b", rem, "ok.sh", "a"),
+			},
+			outcome: outcomeFailed,
+			failed:  "a",
+			notRun:  []string{"a", "b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executor := newTestExecutor(t)
+			incident := &lib.Incident{ID: "test"}
+			run := executor.runWorkflow(context.Background(), incident, test.steps)
+
+			if run.Outcome != test.outcome {
+				t.Errorf("Outcome = %s, want %s", run.Outcome, test.outcome)
+			}
+			failed := ""
+			if run.Failed != nil {
+				failed = run.Failed.Name
+			}
+			if failed != test.failed {
+				t.Errorf("Failed = %q, want %q", failed, test.failed)
+			}
+			if completed := stepNames(run.Completed); !reflect.DeepEqual(completed, test.completed) {
+				t.Errorf("Completed = %v, want %v", completed, test.completed)
+			}
+			if skipped := stepNames(run.Skipped); !reflect.DeepEqual(skipped, test.skipped) {
+				t.Errorf("Skipped = %v, want %v", skipped, test.skipped)
+			}
+			ran := runLog(t, executor)
+			for _, name := range test.notRun {
+				for _, step := range ran {
+					if step == name {
+						t.Errorf("step %s ran, steps run: %v", name, ran)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRunWorkflowConcurrentSteps(t *testing.T) {
+	executor := newTestExecutor(t)
+	steps := []*lib.Step{
+		testStep("audit", confighandler.PhasePreAudit, "ok.sh"),
+		testStep("fix1", confighandler.PhaseRemediation, "ok.sh", "audit"),
+		testStep("fix2", confighandler.PhaseRemediation, "ok.sh", "audit"),
+		testStep("check", confighandler.PhasePostAudit, "ok.sh", "fix1", "fix2"),
+	}
+	run := executor.runWorkflow(context.Background(), &lib.Incident{ID: "test"}, steps)
+	if run.Outcome != outcomeSucceeded {
+		t.Fatalf("Outcome = %s, want %s", run.Outcome, outcomeSucceeded)
+	}
+	completed := stepNames(run.Completed)
+	if len(completed) != 4 || completed[0] != "audit" || completed[3] != "check" {
+		t.Fatalf("Completed = %v, want audit, fix1 and fix2 in any order, check", completed)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	remediation := func(name string, script string, rollback string, dependsOn ...string) *lib.Step {
+		step := testStep(name, confighandler.PhaseRemediation, script, dependsOn...)
+		if rollback != "" {
+			step.Rollback = &lib.Command{Cmd: rollback, Args: []string{name + "/rollback"}}
+		}
+		return step
+	}
+
+	tests := []struct {
+		name      string
+		steps     []*lib.Step
+		outcome   string
+		rollbacks []string
+	}{
+		{
+			name: "reverse completion order",
+			steps: []*lib.Step{
+				testStep("audit", confighandler.PhasePreAudit, "ok.sh"),
+				remediation("first", "ok.sh", "ok.sh", "audit"),
+				remediation("second", "ok.sh", "ok.sh", "first"),
+				testStep("check", confighandler.PhasePostAudit, "fail.sh", "second"),
+			},
+			outcome:   outcomeRolledBack,
+			rollbacks: []string{"second/rollback", "first/rollback"},
+		},
+		{
+			name: "failed and irreversible steps are not rolled back",
+			steps: []*lib.Step{
+				remediation("first", "ok.sh", "ok.sh"),
+				remediation("irreversible", "ok.sh", "", "first"),
+				remediation("second", "fail.sh", "ok.sh", "irreversible"),
+			},
+			outcome:   outcomeRolledBack,
+			rollbacks: []string{"first/rollback"},
+		},
+		{
+			name: "failing rollback stops",
+			steps: []*lib.Step{
+				remediation("first", "ok.sh", "ok.sh"),
+				remediation("second", "ok.sh", "fail.sh", "first"),
+				testStep("check", confighandler.PhasePostAudit, "fail.sh", "second"),
+			},
+			outcome:   outcomeRollbackFailed,
+			rollbacks: []string{"second/rollback"},
+		},
+		{
+			name: "nothing to roll back",
+			steps: []*lib.Step{
+				testStep("audit", confighandler.PhasePreAudit, "fail.sh"),
+				remediation("first", "ok.sh", "ok.sh", "audit"),
+			},
+			outcome: outcomeFailed,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executor := newTestExecutor(t)
+			incident := &lib.Incident{ID: "test"}
+			run := executor.runWorkflow(context.Background(), incident, test.steps)
+			if run.Failed == nil {
+				t.Fatal("the workflow did not fail")
+			}
+			executor.rollback(incident, run)
+
+			if run.Outcome != test.outcome {
+				t.Errorf("Outcome = %s, want %s", run.Outcome, test.outcome)
+			}
+			var rollbacks []string
+			for _, result := range run.Rollbacks {
+				rollbacks = append(rollbacks, result.Step)
+			}
+			if !reflect.DeepEqual(rollbacks, test.rollbacks) {
+				t.Errorf("Rollbacks = %v, want %v", rollbacks, test.rollbacks)
+			}
+			ran := runLog(t, executor)
+			var ranRollbacks []string
+			for _, step := range ran {
+				if strings.HasSuffix(step, "/rollback") {
+					ranRollbacks = append(ranRollbacks, step)
+				}
+			}
+			if !reflect.DeepEqual(ranRollbacks, test.rollbacks) {
+				t.Errorf("rollback scripts run = %v, want %v", ranRollbacks, test.rollbacks)
+			}
+		})
+	}
+}
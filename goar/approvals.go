@@ -0,0 +1,92 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/approvals"
+	"github.com/facebookexperimental/GOAR/confighandler"
+)
+
+// approvalsCommand lists, approves and denies the incidents waiting for approval in
+// APPROVALS_DIR. Executors release approved incidents within a few seconds.
+func approvalsCommand(args []string) int {
+	flags := flag.NewFlagSet("approvals", flag.ExitOnError)
+	configPath := flags.String("config", "../config.yaml", "Path to the configuration file")
+	by := flags.String("by", os.Getenv("USER"), "Name recorded with the decision")
+	reason := flags.String("reason", "", "Reason recorded with the decision")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: goar approvals [flags] list | approve <incident ID> | deny <incident ID>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	conf, err := confighandler.GetConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config %s: %s\n", *configPath, err)
+		return 1
+	}
+	if conf.ApprovalsDir == "" {
+		fmt.Fprintf(os.Stderr, "APPROVALS_DIR is not set in %s\n", *configPath)
+		return 1
+	}
+	store, err := approvals.NewStore(conf.ApprovalsDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening approvals store %s: %s\n", conf.ApprovalsDir, err)
+		return 1
+	}
+
+	switch action := flags.Arg(0); {
+	case action == "list" && flags.NArg() == 1:
+		return listApprovals(store)
+	case (action == "approve" || action == "deny") && flags.NArg() == 2:
+		if *by == "" {
+			fmt.Fprintln(os.Stderr, "-by is required")
+			return 2
+		}
+		request, err := store.Decide(flags.Arg(1), action == "approve", *by, *reason)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot %s incident %s: %s\n", action, flags.Arg(1), err)
+			return 1
+		}
+		fmt.Printf("Incident %s %s by %s\n", request.ID, request.Status, request.DecidedBy)
+		return 0
+	}
+	flags.Usage()
+	return 2
+}
+
+func listApprovals(store *approvals.Store) int {
+	requests, err := store.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing approval requests: %s\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INCIDENT\tRULE\tDEVICE\tSTATUS\tREQUESTED\tEXPIRES")
+	for _, request := range requests {
+		status := request.Status
+		if status == approvals.StatusPending && time.Now().After(request.Expires) {
+			status = approvals.StatusExpired
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			request.ID,
+			request.Incident.Rule.RuleName,
+			request.Device,
+			status,
+			request.Requested.Format(time.RFC3339),
+			request.Expires.Format(time.RFC3339))
+	}
+	w.Flush()
+	return 0
+}
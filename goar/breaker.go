@@ -0,0 +1,92 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/endpoints"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// breakerCommand resets the breaker of a rule or a script on every executor, by
+// publishing a breaker-reset event to RESULTS_EXCHANGE.
+func breakerCommand(args []string) int {
+	flags := flag.NewFlagSet("breaker", flag.ExitOnError)
+	configPath := flags.String("config", "../config.yaml", "Path to the configuration file")
+	by := flags.String("by", os.Getenv("USER"), "Name recorded with the reset")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: goar breaker [flags] reset rule <rule name> | reset script <script>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 3 || flags.Arg(0) != "reset" {
+		flags.Usage()
+		return 2
+	}
+	event := &lib.Event{
+		Type: lib.EventBreakerReset,
+		Time: time.Now(),
+		By:   *by,
+	}
+	switch name := flags.Arg(2); flags.Arg(1) {
+	case "rule":
+		event.Rule = name
+		event.Breaker = "rule:" + name
+	case "script":
+		event.Breaker = "script:" + name
+	default:
+		flags.Usage()
+		return 2
+	}
+	event.Executor, _ = os.Hostname()
+
+	conf, err := confighandler.GetConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config %s: %s\n", *configPath, err)
+		return 1
+	}
+	if conf.ResultsExchange == "" {
+		fmt.Fprintf(os.Stderr, "RESULTS_EXCHANGE is not set in %s, restart the executors to reset their breakers\n", *configPath)
+		return 1
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshaling the reset event: %s\n", err)
+		return 1
+	}
+	var endpoint endpoints.RabbitMQEndpoint
+	if err := endpoint.Connect(conf); err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to RabbitMQ: %s\n", err)
+		return 1
+	}
+	defer endpoint.Close()
+	if err := endpoint.Channel.Publish(
+		conf.ResultsExchange,      // exchange
+		event.Type+"."+event.Rule, // routing key
+		false,                     // mandatory
+		false,                     // immediate
+		amqp.Publishing{
+			ContentType: "application/json",
+			Timestamp:   event.Time,
+			Body:        body,
+		}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error publishing the reset event: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Breaker %s reset on every executor\n", event.Breaker)
+	return 0
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/history"
+)
+
+// historyCommand prints the records of HISTORY_DIR matching the flags, oldest first.
+func historyCommand(args []string) int {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	configPath := flags.String("config", "../config.yaml", "Path to the configuration file")
+	device := flags.String("device", "", "Only the incidents of this device: a hostname, or any value of the rule LockKeys")
+	rule := flags.String("rule", "", "Only the incidents of this rule")
+	status := flags.String("status", "", "Only the records with this status (succeeded, failed, rolledback, retried..., started for the handlings that did not end)")
+	since := flags.String("since", "24h", "Only the records started since this time: RFC3339, YYYY-MM-DD or a duration ago (e.g. 2h)")
+	until := flags.String("until", "", "Only the records started until this time, same formats as -since")
+	format := flags.String("format", "table", "Output format: table, or json (one record per line)")
+	steps := flags.Bool("steps", false, "Print the steps and the context of each record in the table")
+	limit := flags.Int("limit", 0, "Only the most recent records, 0 for all")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: goar history [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 || (*format != "table" && *format != "json") {
+		flags.Usage()
+		return 2
+	}
+
+	filter := history.Filter{Device: *device, Rule: *rule, Status: *status}
+	var err error
+	if filter.Since, err = parseTime(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -since: %s\n", err)
+		return 2
+	}
+	if filter.Until, err = parseTime(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -until: %s\n", err)
+		return 2
+	}
+
+	conf, err := confighandler.GetConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config %s: %s\n", *configPath, err)
+		return 1
+	}
+	if conf.HistoryDir == "" {
+		fmt.Fprintf(os.Stderr, "HISTORY_DIR is not set in %s\n", *configPath)
+		return 1
+	}
+	store, err := history.NewStore(conf.HistoryDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening history store %s: %s\n", conf.HistoryDir, err)
+		return 1
+	}
+	records, err := store.Query(filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading history: %s\n", err)
+		return 1
+	}
+	if *limit > 0 && len(records) > *limit {
+		records = records[len(records)-*limit:]
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing record: %s\n", err)
+				return 1
+			}
+		}
+		return 0
+	}
+	printHistory(records, *steps)
+	return 0
+}
+
+// parseTime parses value as a RFC3339 time, a YYYY-MM-DD day (local time) or a
+// duration before now. An empty value is the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func printHistory(records []*history.Record, steps bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tDEVICE\tRULE\tSTATUS\tATTEMPT\tDURATION\tINCIDENT\tREASON")
+	for _, record := range records {
+		reason := record.Reason
+		if record.By != "" {
+			reason = strings.TrimSpace("by " + record.By + " " + reason)
+		}
+		duration := "-"
+		if !record.Finished.IsZero() {
+			duration = record.Finished.Sub(record.Started).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			record.Started.Local().Format(time.RFC3339),
+			record.Device,
+			record.Rule,
+			record.Status,
+			record.Attempt,
+			duration,
+			record.IncidentID,
+			reason)
+		if !steps {
+			continue
+		}
+		for _, step := range record.Steps {
+			result := "passed"
+			if !step.Passed {
+				result = "failed"
+			}
+			if step.Error != "" {
+				result += ": " + step.Error
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t\t%s\t%q\t\n",
+				step.Started.Local().Format("15:04:05"),
+				step.Phase,
+				step.Name,
+				result,
+				step.Duration.Round(time.Millisecond),
+				step.Argv)
+		}
+		if len(record.Context) > 0 {
+			context, err := json.Marshal(record.Context)
+			if err != nil {
+				context = []byte(err.Error())
+			}
+			fmt.Fprintf(w, "  context\t%s\n", context)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// commands maps each goar sub command to the function running it.
+// Every command receives its own arguments and returns the process exit code.
+var commands = map[string]func(args []string) int{
+	"approvals": approvalsCommand,
+	"breaker":   breakerCommand,
+	"history":   historyCommand,
+	"test":      testRules,
+	"validate":  validate,
+}
+
+// goar is the operator tool for GOAR: goar <command> [flags]
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(command(os.Args[2:]))
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: goar <command> [flags]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/matcher"
+)
+
+// testRules runs the Examples and NonExamples of every rule through the same matching
+// code used by the processor, over the full rules file. Besides failed expectations it
+// reports first-match collisions: example lines matched by more than one rule, where
+// only the first one creates an incident. Returns 1 if any expectation failed.
+func testRules(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	configPath := flags.String("config", "../config.yaml", "Path to the configuration file")
+	rulesPath := flags.String("rules", "", "Path to the rules file, RULESFILE from the config by default")
+	flags.Parse(args)
+
+	conf, err := confighandler.GetConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config %s: %s\n", *configPath, err)
+		return 1
+	}
+	if *rulesPath == "" {
+		*rulesPath = conf.RulesFile
+	}
+
+	header, err := matcher.NewHeader(conf.BaseReg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error compiling BASEREG: %s\n", err)
+		return 1
+	}
+	rules, err := confighandler.GetRules(*rulesPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading rules %s: %s\n", *rulesPath, err)
+		return 1
+	}
+	ruleSet, err := matcher.NewRuleSet(rules)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error compiling rules %s: %s\n", *rulesPath, err)
+		return 1
+	}
+
+	var failures, collisions, examples int
+	for id, rule := range ruleSet.Rules {
+		for _, example := range rule.Examples {
+			examples++
+			expected := example.Rule
+			if expected == "" {
+				expected = rule.RuleName
+			}
+
+			if problem := checkExample(ruleSet, header, example, expected); problem != "" {
+				failures++
+				fmt.Printf("FAIL %s: %s\n     line: %q\n", rule.RuleName, problem, example.Line)
+			}
+
+			if ids := ruleSet.MatchAll(example.Line); len(ids) > 1 {
+				collisions++
+				fmt.Printf("COLLISION %s: line matched by %s, only %s creates an incident\n     line: %q\n",
+					rule.RuleName, ruleNames(ruleSet, ids), ruleSet.Rules[ids[0]].RuleName, example.Line)
+			}
+		}
+
+		for _, line := range rule.NonExamples {
+			examples++
+			for _, matched := range ruleSet.MatchAll(line) {
+				if matched == id {
+					failures++
+					fmt.Printf("FAIL %s: non example matched the rule\n     line: %q\n", rule.RuleName, line)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("%d example(s), %d failure(s), %d collision(s)\n", examples, failures, collisions)
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkExample matches a single example and describes how it differs from what
+// is expected, empty string if it behaves as expected.
+func checkExample(ruleSet *matcher.RuleSet, header *matcher.Header, example confighandler.RuleExample, expected string) string {
+	match := ruleSet.Match(example.Line, header)
+	if match == nil {
+		return fmt.Sprintf("expected rule %s, no rule matched", expected)
+	}
+	if match.Rule.RuleName != expected {
+		return fmt.Sprintf("expected rule %s, shadowed by %s", expected, match.Rule.RuleName)
+	}
+	if !match.HeaderMatched {
+		return "BASEREG header did not match"
+	}
+
+	names := make([]string, 0, len(example.Parameters))
+	for name := range example.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var mismatches []string
+	for _, name := range names {
+		value := example.Parameters[name]
+		if got, ok := match.Parameters[name]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("%s missing", name))
+		} else if got != value {
+			mismatches = append(mismatches, fmt.Sprintf("%s=%q, expected %q", name, got, value))
+		}
+	}
+	if len(mismatches) > 0 {
+		return "parameters " + strings.Join(mismatches, ", ")
+	}
+	return ""
+}
+
+// ruleNames returns the comma separated names of the rules at ids.
+func ruleNames(ruleSet *matcher.RuleSet, ids []int) string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		names = append(names, ruleSet.Rules[id].RuleName)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/matcher"
+)
+
+func TestCheckExample(t *testing.T) {
+	ruleSet, err := matcher.NewRuleSet([]confighandler.Rule{
+		{RuleName: "interface_down", Regex: `Interface (?<interface>\S+), changed state to down`},
+		{RuleName: "any_down", Regex: `changed state to (?<state>\w+)`},
+	})
+	if err != nil {
+		t.Fatalf("NewRuleSet: %s", err)
+	}
+	header, err := matcher.NewHeader(`^\w{3} \d{2} \S+ (?<hostname>\w+) `)
+	if err != nil {
+		t.Fatalf("NewHeader: %s", err)
+	}
+
+	const line = "Mar 12 10:00:01 test_device Ebra: Interface Ethernet1, changed state to down"
+	tests := []struct {
+		name     string
+		line     string
+		params   map[string]string
+		expected string
+		problem  string
+	}{
+		{
+			name:     "match",
+			line:     line,
+			params:   map[string]string{"hostname": "test_device", "interface": "Ethernet1"},
+			expected: "interface_down",
+		},
+		{
+			name:     "match without expected parameters",
+			line:     line,
+			expected: "interface_down",
+		},
+		{
+			name:     "no rule matching",
+			line:     "Mar 12 10:00:01 test_device Ebra: all good",
+			expected: "interface_down",
+			problem:  "expected rule interface_down, no rule matched",
+		},
+		{
+			name:     "shadowed rule",
+			line:     line,
+			expected: "any_down",
+			problem:  "expected rule any_down, shadowed by interface_down",
+		},
+		{
+			name:     "header mismatch",
+			line:     "Interface Ethernet1, changed state to down",
+			expected: "interface_down",
+			problem:  "BASEREG header did not match",
+		},
+		{
+			name:     "parameter mismatch",
+			line:     line,
+			params:   map[string]string{"hostname": "test_device", "interface": "Ethernet2"},
+			expected: "interface_down",
+			problem:  `parameters interface="Ethernet1", expected "Ethernet2"`,
+		},
+		{
+			name:     "missing parameters",
+			line:     line,
+			params:   map[string]string{"interface": "Ethernet1", "state": "down", "vlan": "10"},
+			expected: "interface_down",
+			problem:  "parameters state missing, vlan missing",
+		},
+		{
+			name:     "missing and mismatched parameters",
+			line:     line,
+			params:   map[string]string{"interface": "Ethernet1/1", "hostname": "peer_device", "speed": "10G"},
+			expected: "interface_down",
+			problem:  `parameters hostname="test_device", expected "peer_device", interface="Ethernet1", expected "Ethernet1/1", speed missing`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			example := confighandler.RuleExample{Line: test.line, Parameters: test.params}
+			if problem := checkExample(ruleSet, header, example, test.expected); problem != test.problem {
+				t.Fatalf("checkExample = %q, want %q", problem, test.problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+)
+
+// validate lints the config and rules files, printing one line-numbered error
+// per problem found. Returns 1 if any problem was found.
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", "../config.yaml", "Path to the configuration file")
+	rulesPath := flags.String("rules", "", "Path to the rules file, RULESFILE from the config by default")
+	workflowsPath := flags.String("workflows", "", "Path to the workflows file, WORKFLOWSFILE from the config by default")
+	remediationsPath := flags.String("remediations", "../remediations/", "Path to the directory with your remediations scripts")
+	flags.Parse(args)
+
+	errs := confighandler.ValidateConfig(*configPath)
+
+	conf, _ := confighandler.GetConfig(*configPath)
+	if *rulesPath == "" {
+		if conf.RulesFile == "" {
+			fmt.Fprintln(os.Stderr, "No rules file to validate, use -rules or set RULESFILE in the config")
+			return 1
+		}
+		*rulesPath = conf.RulesFile
+	}
+	if *workflowsPath == "" {
+		*workflowsPath = conf.WorkflowsFile
+	}
+	errs = append(errs, confighandler.ValidateRules(*rulesPath, *workflowsPath, *remediationsPath)...)
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(errs))
+		return 1
+	}
+	fmt.Printf("%s and %s are valid\n", *configPath, *rulesPath)
+	return 0
+}
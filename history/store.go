@@ -0,0 +1,226 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package history stores what the executors did to the devices, to be queried afterwards.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// Record is one handling of an incident by an executor: from the moment it started
+// processing the incident until it acked it, or an approval event.
+type Record struct {
+	IncidentID string
+	Rule       string
+	// Device is the device key of the incident, see lib.Event.Device.
+	Device     string
+	Parameters map[string]string
+	// Executor is the hostname of the executor.
+	Executor string
+	Attempt  int `json:",omitempty"`
+	// Status is the type of the lib.Event that ended the handling: succeeded, failed,
+	// rolledback, retried, delayed, dryrun, awaiting-approval, approved, denied, expired, quarantined
+	// or suppressed, the latter being the count of the incidents suppressed during its window.
+	// A started record is written when the handling starts, see Store.Query.
+	Status string
+	Reason string `json:",omitempty"`
+	// By is who approved or denied the incident.
+	By       string `json:",omitempty"`
+	Started  time.Time
+	Finished time.Time
+	// State of the incident at the end and the lifecycle transitions it went through.
+	State       string           `json:",omitempty"`
+	Transitions []lib.Transition `json:",omitempty"`
+	// Context of the incident at the end, the data returned by its steps.
+	Context map[string]interface{} `json:",omitempty"`
+	// Steps executed, in completion order.
+	Steps []*lib.StepResult `json:",omitempty"`
+}
+
+// Filter selects records, zero fields matching every record.
+type Filter struct {
+	// Device is a device key, or one of its values (e.g. a hostname).
+	Device string
+	Rule   string
+	Status string
+	// Since and Until bound the Started time of the records.
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether record is selected by filter.
+func (filter Filter) Match(record *Record) bool {
+	switch {
+	case filter.Device != "" && !matchDevice(record.Device, filter.Device):
+		return false
+	case filter.Rule != "" && record.Rule != filter.Rule:
+		return false
+	case filter.Status != "" && record.Status != filter.Status:
+		return false
+	case !filter.Since.IsZero() && record.Started.Before(filter.Since):
+		return false
+	case !filter.Until.IsZero() && record.Started.After(filter.Until):
+		return false
+	}
+	return true
+}
+
+// matchDevice reports whether device is the device key key or one of its values.
+func matchDevice(key string, device string) bool {
+	if key == device {
+		return true
+	}
+	for _, pair := range strings.Split(key, ",") {
+		if i := strings.Index(pair, "="); i >= 0 && pair[i+1:] == device {
+			return true
+		}
+	}
+	return false
+}
+
+// Store appends the records as JSON lines to a file per day (UTC) of a directory,
+// which can be shared by several executors and the goar command. Old files can
+// be compressed or removed like log files.
+type Store struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// File names are <filePrefix><day><fileSuffix>, day being in dayLayout.
+const (
+	filePrefix = "history-"
+	fileSuffix = ".jsonl"
+	dayLayout  = "2006-01-02"
+)
+
+// NewStore returns the store of the records in dir, creating it if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Add appends record to the file of the day it started. The record is written
+// in a single append, so that the lines of several executors do not interleave.
+func (store *Store) Add(record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	path := filepath.Join(store.dir, filePrefix+record.Started.UTC().Format(dayLayout)+fileSuffix)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Query returns the records matching filter, oldest first. Only the files of the days
+// between filter.Since and filter.Until are read. Lines that are not valid records,
+// e.g. truncated by a crash, are skipped. The started record of a handling is left out
+// once the handling ended, so started records are the handlings in progress, or that
+// never ended.
+func (store *Store) Query(filter Filter) ([]*Record, error) {
+	paths, err := filepath.Glob(filepath.Join(store.dir, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for _, path := range paths {
+		day, err := time.Parse(dayLayout, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), filePrefix), fileSuffix))
+		if err != nil {
+			// not a history file
+			continue
+		}
+		if !filter.Since.IsZero() && day.AddDate(0, 0, 1).Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && day.After(filter.Until) {
+			continue
+		}
+
+		// the status is only filtered once the ended handlings are known
+		unfiltered := filter
+		unfiltered.Status = ""
+		if records, err = readFile(path, unfiltered, records); err != nil {
+			return nil, err
+		}
+	}
+
+	type handling struct {
+		incidentID string
+		executor   string
+		started    time.Time
+	}
+	ended := make(map[handling]bool, len(records))
+	for _, record := range records {
+		if record.Status != lib.EventStarted {
+			ended[handling{record.IncidentID, record.Executor, record.Started.UTC()}] = true
+		}
+	}
+	selected := records[:0]
+	for _, record := range records {
+		if filter.Status != "" && record.Status != filter.Status {
+			continue
+		}
+		if record.Status == lib.EventStarted && ended[handling{record.IncidentID, record.Executor, record.Started.UTC()}] {
+			continue
+		}
+		selected = append(selected, record)
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].Started.Before(selected[j].Started)
+	})
+	return selected, nil
+}
+
+// readFile appends to records the records of the file at path matching filter.
+func readFile(path string, filter Filter, records []*Record) ([]*Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var record Record
+			if json.Unmarshal(line, &record) == nil && filter.Match(&record) {
+				records = append(records, &record)
+			}
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// testStart is when the first record of the tests started.
+var testStart = time.Date(2020, time.March, 1, 23, 0, 0, 0, time.UTC)
+
+func TestFilterMatch(t *testing.T) {
+	record := &Record{
+		IncidentID: "test",
+		Rule:       "interface_down",
+		Device:     "hostname=r1,interface=et1",
+		Status:     lib.EventSucceeded,
+		Started:    testStart,
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		match  bool
+	}{
+		{"empty", Filter{}, true},
+		{"device key", Filter{Device: "hostname=r1,interface=et1"}, true},
+		{"device value", Filter{Device: "r1"}, true},
+		{"other value", Filter{Device: "et1"}, true},
+		{"other device", Filter{Device: "r2"}, false},
+		{"partial value", Filter{Device: "r"}, false},
+		{"rule", Filter{Rule: "interface_down"}, true},
+		{"other rule", Filter{Rule: "bgp_down"}, false},
+		{"status", Filter{Status: lib.EventSucceeded}, true},
+		{"other status", Filter{Status: lib.EventFailed}, false},
+		{"since", Filter{Since: testStart.Add(-time.Hour)}, true},
+		{"since later", Filter{Since: testStart.Add(time.Hour)}, false},
+		{"until", Filter{Until: testStart.Add(time.Hour)}, true},
+		{"until earlier", Filter{Until: testStart.Add(-time.Hour)}, false},
+		{"all", Filter{Device: "r1", Rule: "interface_down", Status: lib.EventSucceeded, Since: testStart, Until: testStart}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if match := test.filter.Match(record); match != test.match {
+				t.Errorf("Match = %t, want %t", match, test.match)
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a record of incident id handled by executor, starting after the start of the tests
+	newRecord := func(id string, executor string, after time.Duration, status string) *Record {
+		return &Record{IncidentID: id, Rule: "rule", Device: "hostname=r1", Executor: executor, Started: testStart.Add(after), Status: status}
+	}
+	for _, record := range []*Record{
+		// ended, its started record is left out
+		newRecord("a", "e1", 0, lib.EventStarted),
+		newRecord("a", "e1", 0, lib.EventRetried),
+		// retried attempt, on the next day, never ended
+		newRecord("a", "e2", 2*time.Hour, lib.EventStarted),
+		// started by two executors at the same time
+		newRecord("b", "e1", time.Minute, lib.EventStarted),
+		newRecord("b", "e2", time.Minute, lib.EventStarted),
+		newRecord("b", "e2", time.Minute, lib.EventSucceeded),
+		// approval event
+		newRecord("c", "e1", 3*time.Hour, lib.EventApproved),
+	} {
+		if err := store.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// truncated by a crash
+	file, err := os.OpenFile(filepath.Join(store.dir, filePrefix+"2020-03-02"+fileSuffix), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"IncidentID": "d", "Rule"`)
+	file.Close()
+
+	tests := []struct {
+		name   string
+		filter Filter
+		// records selected, as incident/executor/status
+		records []string
+	}{
+		{
+			name:    "all",
+			records: []string{"a/e1/retried", "b/e1/started", "b/e2/succeeded", "a/e2/started", "c/e1/approved"},
+		},
+		{
+			name:    "started",
+			filter:  Filter{Status: lib.EventStarted},
+			records: []string{"b/e1/started", "a/e2/started"},
+		},
+		{
+			name:    "since",
+			filter:  Filter{Since: testStart.Add(90 * time.Minute)},
+			records: []string{"a/e2/started", "c/e1/approved"},
+		},
+		{
+			name:    "until",
+			filter:  Filter{Until: testStart.Add(30 * time.Second)},
+			records: []string{"a/e1/retried"},
+		},
+		{
+			name:   "other device",
+			filter: Filter{Device: "r2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := store.Query(test.filter)
+			if err != nil {
+				t.Fatalf("Query: %s", err)
+			}
+			var selected []string
+			for _, record := range records {
+				selected = append(selected, record.IncidentID+"/"+record.Executor+"/"+record.Status)
+			}
+			if !reflect.DeepEqual(selected, test.records) {
+				t.Errorf("records %v, want %v", selected, test.records)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package lib
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Types of the events published by the executors to the results exchange.
+const (
+	// The executor started processing the incident.
+	EventStarted = "started"
+	// A step finished, see Event.Step.
+	EventStep = "step"
+	// The incident failed and will be retried after Event.Delay.
+	EventRetried = "retried"
+	// The incident could not be processed yet (e.g. its device is locked),
+	// it will be processed after Event.Delay.
+	EventDelayed = "delayed"
+	// Every step of the incident passed.
+	EventSucceeded = "succeeded"
+	// The incident failed for good, it went to the dead-letter queue.
+	EventFailed = "failed"
+	// A step failed and the remediations that succeeded were rolled back.
+	EventRolledBack = "rolledback"
+	// Event.SuppressedCount incidents identical to the incident were dropped during its
+	// Suppression window, reported by the executor getting its follow-up.
+	EventSuppressed = "suppressed"
+	// The incident ran in dryrun or audit-only mode, see Event.Reason.
+	EventDryRun = "dryrun"
+	// The pre-audits passed and the incident waits for approval.
+	EventAwaitingApproval = "awaiting-approval"
+	// Someone approved or denied the incident, or nobody did in time.
+	EventApproved = "approved"
+	EventDenied   = "denied"
+	EventExpired  = "expired"
+	// The breaker of the rule or of a script of the incident is open, it went to the
+	// quarantine queue, see Event.Breaker.
+	EventQuarantined = "quarantined"
+	// Event.Breaker opened or closed, published by each executor seeing it happen.
+	EventBreakerOpened = "breaker-opened"
+	EventBreakerClosed = "breaker-closed"
+	// An operator reset Event.Breaker, published by goar breaker reset.
+	EventBreakerReset = "breaker-reset"
+)
+
+// Event is published by the executors to the results exchange every time an incident
+// changes state, with <Type>.<rule name> as routing key.
+type Event struct {
+	Type string
+	Time time.Time
+	// Executor is the hostname of the executor publishing the event.
+	Executor   string
+	IncidentID string
+	Rule       string
+	Parameters map[string]string
+	// Device the incident acts on: the values of the LockKeys parameters of its rule, as
+	// name=value pairs separated by commas (hostname=<hostname> by default).
+	Device string `json:",omitempty"`
+	// State of the incident after the event and the transitions it went through,
+	// since it was published for this attempt.
+	State       string       `json:",omitempty"`
+	Transitions []Transition `json:",omitempty"`
+	// Context of the incident after the event: the data returned by its steps so far.
+	Context map[string]interface{} `json:",omitempty"`
+	// Attempt of the incident, for retried events the attempt coming next.
+	Attempt int `json:",omitempty"`
+	// Step is set on step events.
+	Step *StepResult `json:",omitempty"`
+	// Reason of failed, retried, delayed, dryrun, denied and expired events.
+	Reason string `json:",omitempty"`
+	// Delay before the next attempt of retried and delayed events.
+	Delay time.Duration `json:",omitempty"`
+	// By is who approved or denied the incident, or reset the breaker.
+	By string `json:",omitempty"`
+	// Breaker of quarantined and breaker events: rule:<rule name> or script:<script>.
+	Breaker string `json:",omitempty"`
+	// SuppressedCount of suppressed events.
+	SuppressedCount int `json:",omitempty"`
+}
+
+// StepResult describes the execution of a step.
+type StepResult struct {
+	Name  string
+	Phase string
+	// Script is the path of the script in the remediations directory, Argv its command line.
+	Script string
+	Argv   []string
+	// Passed is true if the step counts as passed: the script ran and its output says so.
+	Passed bool
+	// Simulated is true if the step was only logged, see Rule.Mode.
+	Simulated bool `json:",omitempty"`
+	// ExitCode is the executor exit code, 0 if the script ran and returned its output.
+	ExitCode int
+	Error    string `json:",omitempty"`
+	// Output is the JSON object printed by the script on stdout.
+	Output   json.RawMessage `json:",omitempty"`
+	Stderr   string          `json:",omitempty"`
+	Started  time.Time
+	Duration time.Duration
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package lib
+
+import (
+	"fmt"
+	"time"
+)
+
+// States of the incident lifecycle.
+const (
+	// The processor matched a rule.
+	StateDetected = "detected"
+	// The processor published the incident, or the executor is going to retry it.
+	StateQueued = "queued"
+	// The executor runs its pre-audits.
+	StateAuditing = "auditing"
+	// The pre-audits passed and the incident waits for approval.
+	StateAwaitingApproval = "awaiting-approval"
+	// The executor runs its remediations.
+	StateRemediating = "remediating"
+	// The executor runs its post-audits.
+	StateVerifying = "verifying"
+	// Final states.
+	StateResolved   = "resolved"
+	StateFailed     = "failed"
+	StateRolledBack = "rolled-back"
+	// The incident ran in dryrun or audit-only mode and every step that ran passed.
+	StateSimulated = "simulated"
+	// Collapsed into another incident by the processor, never published.
+	StateSuppressed = "suppressed"
+)
+
+// transitions lists the states each state can go to, final states having none.
+var transitions = map[string][]string{
+	StateDetected:         {StateQueued, StateSuppressed},
+	StateQueued:           {StateAuditing, StateAwaitingApproval, StateRemediating, StateVerifying, StateResolved, StateFailed, StateSimulated},
+	StateAuditing:         {StateAwaitingApproval, StateRemediating, StateVerifying, StateResolved, StateFailed, StateQueued, StateSimulated},
+	StateAwaitingApproval: {StateQueued, StateFailed},
+	StateRemediating:      {StateVerifying, StateResolved, StateFailed, StateRolledBack, StateQueued},
+	StateVerifying:        {StateResolved, StateFailed, StateRolledBack, StateQueued, StateSimulated},
+}
+
+// Transition records when an incident entered a state.
+type Transition struct {
+	State string
+	At    time.Time
+}
+
+// Final reports whether state ends the lifecycle of an incident.
+func Final(state string) bool {
+	_, ok := transitions[state]
+	return !ok && state != ""
+}
+
+// Transition moves the incident to state, recording the time in Transitions.
+// Moving to the current state does nothing. It returns an error, leaving the
+// incident as is, if the lifecycle does not allow going from the current state
+// to state. Incidents without a state, published by older processors, can go to any state.
+func (inc *Incident) Transition(state string) error {
+	return inc.TransitionAt(state, time.Now())
+}
+
+// TransitionAt is Transition recording at as the time the incident entered state.
+func (inc *Incident) TransitionAt(state string, at time.Time) error {
+	if state == inc.State {
+		return nil
+	}
+	if inc.State != "" && !allowed(inc.State, state) {
+		return fmt.Errorf("incident %s cannot go from %s to %s", inc.ID, inc.State, state)
+	}
+	inc.State = state
+	inc.Transitions = append(inc.Transitions, Transition{State: state, At: at})
+	return nil
+}
+
+func allowed(from string, to string) bool {
+	for _, state := range transitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
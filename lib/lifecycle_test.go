@@ -0,0 +1,159 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIncidentTransition(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   string
+		to     []string
+		state  string
+		states []string
+		err    bool
+	}{
+		{
+			name:   "remediated",
+			from:   StateDetected,
+			to:     []string{StateQueued, StateAuditing, StateRemediating, StateVerifying, StateResolved},
+			state:  StateResolved,
+			states: []string{StateQueued, StateAuditing, StateRemediating, StateVerifying, StateResolved},
+		},
+		{
+			name:   "retried",
+			from:   StateQueued,
+			to:     []string{StateRemediating, StateQueued, StateRemediating, StateRolledBack},
+			state:  StateRolledBack,
+			states: []string{StateRemediating, StateQueued, StateRemediating, StateRolledBack},
+		},
+		{
+			name:   "suppressed",
+			from:   StateDetected,
+			to:     []string{StateSuppressed},
+			state:  StateSuppressed,
+			states: []string{StateSuppressed},
+		},
+		{
+			name:  "same state",
+			from:  StateQueued,
+			to:    []string{StateQueued},
+			state: StateQueued,
+		},
+		{
+			name:   "no state",
+			from:   "",
+			to:     []string{StateRemediating},
+			state:  StateRemediating,
+			states: []string{StateRemediating},
+		},
+		{
+			name:  "from a final state",
+			from:  StateResolved,
+			to:    []string{StateQueued},
+			state: StateResolved,
+			err:   true,
+		},
+		{
+			name:  "skipping queued",
+			from:  StateDetected,
+			to:    []string{StateRemediating},
+			state: StateDetected,
+			err:   true,
+		},
+		{
+			name:  "suppressed once queued",
+			from:  StateQueued,
+			to:    []string{StateSuppressed},
+			state: StateQueued,
+			err:   true,
+		},
+		{
+			name:   "approval",
+			from:   StateAuditing,
+			to:     []string{StateAwaitingApproval, StateQueued, StateRemediating},
+			state:  StateRemediating,
+			states: []string{StateAwaitingApproval, StateQueued, StateRemediating},
+		},
+		{
+			name:   "approval without audits",
+			from:   StateQueued,
+			to:     []string{StateAwaitingApproval, StateQueued, StateRemediating, StateResolved},
+			state:  StateResolved,
+			states: []string{StateAwaitingApproval, StateQueued, StateRemediating, StateResolved},
+		},
+		{
+			name:   "simulated",
+			from:   StateQueued,
+			to:     []string{StateAuditing, StateVerifying, StateSimulated},
+			state:  StateSimulated,
+			states: []string{StateAuditing, StateVerifying, StateSimulated},
+		},
+		{
+			name:  "simulated once remediating",
+			from:  StateRemediating,
+			to:    []string{StateSimulated},
+			state: StateRemediating,
+			err:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			incident := &Incident{ID: "test", State: test.from}
+			var err error
+			for _, state := range test.to {
+				if err = incident.Transition(state); err != nil {
+					break
+				}
+			}
+			if (err != nil) != test.err {
+				t.Fatalf("Transition error = %v, want error %t", err, test.err)
+			}
+			if incident.State != test.state {
+				t.Errorf("State = %s, want %s", incident.State, test.state)
+			}
+			var states []string
+			for _, transition := range incident.Transitions {
+				if transition.At.IsZero() {
+					t.Errorf("transition to %s has no time", transition.State)
+				}
+				states = append(states, transition.State)
+			}
+			if !reflect.DeepEqual(states, test.states) {
+				t.Errorf("Transitions = %v, want %v", states, test.states)
+			}
+		})
+	}
+}
+
+func TestFinal(t *testing.T) {
+	tests := []struct {
+		state string
+		final bool
+	}{
+		{StateDetected, false},
+		{StateQueued, false},
+		{StateAuditing, false},
+		{StateAwaitingApproval, false},
+		{StateRemediating, false},
+		{StateVerifying, false},
+		{StateResolved, true},
+		{StateFailed, true},
+		{StateRolledBack, true},
+		{StateSuppressed, true},
+		{StateSimulated, true},
+		{"", false},
+	}
+	for _, test := range tests {
+		if final := Final(test.state); final != test.final {
+			t.Errorf("Final(%q) = %t, want %t", test.state, final, test.final)
+		}
+	}
+}
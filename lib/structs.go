@@ -7,7 +7,13 @@
 package lib
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/facebookexperimental/GOAR/confighandler"
 )
@@ -16,19 +22,112 @@ import (
 type Command struct {
 	// Process name to be executied
 	Cmd string
-	// Arguments to be passed when executing process
-	Args string
+	// Arguments to be passed when executing process, one argv element each.
+	// See FormatArguments for the convention used for incident parameters.
+	Args []string
+}
+
+// UnmarshalJSON decodes a Command, accepting Args either as a list or,
+// as published by older processors, as a single space separated string.
+func (command *Command) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Cmd  string
+		Args json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	command.Cmd = raw.Cmd
+	command.Args = nil
+
+	if len(raw.Args) == 0 || string(raw.Args) == "null" {
+		return nil
+	}
+	if raw.Args[0] == '"' {
+		var args string
+		if err := json.Unmarshal(raw.Args, &args); err != nil {
+			return err
+		}
+		command.Args = strings.Fields(args)
+		return nil
+	}
+	return json.Unmarshal(raw.Args, &command.Args)
+}
+
+// FormatArguments converts incident parameters into the arguments passed to audits
+// and remediations: one "--name=value" argv element per parameter, sorted by name.
+// No shell is involved, values with spaces or shell metacharacters arrive intact.
+func FormatArguments(params map[string]string) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	arguments := make([]string, 0, len(names))
+	for _, name := range names {
+		arguments = append(arguments, fmt.Sprintf("--%s=%s", name, params[name]))
+	}
+	return arguments
 }
 
 // Incident structure used for incidents recorded
 type Incident struct {
+	// ID identifies the incident in the logs of every component. The processor assigns it
+	// with CreatedAt when the incident is detected, the executor to incidents published without it.
+	ID        string
+	CreatedAt time.Time
+	// State in the incident lifecycle, see Transition.
+	State string `json:",omitempty"`
+	// Transitions lists when the incident entered each of its states so far.
+	Transitions  []Transition `json:",omitempty"`
 	Rule         confighandler.Rule
 	RawIncident  string
+	RawIncidents []string // Every line that contributed to an incident of a rule with a Threshold
 	Engine       string
 	PreAudits    []*Command // All pre-audits needs to be successful for remediation to occur
 	Remediations []*Command // Set of code that fix an issue or are part of a workflow (provision IP, discover neighbors, etc)
 	PostAudits   []*Command // All post-audits needs to be succesul, should be code that makes sure everything is good after the execution
+	Rollbacks    []*Command // Undo the remediations with the same index, an empty Cmd when a remediation has none
+	Workflow     []*Step    // Steps of the rule workflow, replaces the four lists above when set
 	Parameters   map[string]string
+	// Number of identical incidents collapsed into FollowUpOf by the rule Suppression
+	SuppressedCount int `json:",omitempty"`
+	// FollowUpOf is the ID of the incident a follow-up reports on: when the Suppression
+	// window of an incident ends with identical incidents dropped, the processor publishes
+	// a follow-up carrying their count. Follow-ups run nothing, executors only report them.
+	FollowUpOf string `json:",omitempty"`
+	// Context accumulates the data returned by the steps already executed,
+	// it is passed to the following ones.
+	Context map[string]interface{}
+	// Approval is set once the incident was approved, its rule RequiresApproval.
+	Approval *Approval `json:",omitempty"`
+	// LockToken is the fencing token of the device lease held by the executor, scripts
+	// acting on the device can reject tokens older than the last one they have seen.
+	LockToken uint64 `json:",omitempty"`
+	// Incident of the other end of the link, merged into this one by the topology correlation.
+	// Its parameters are also available in Parameters with a "peer_" prefix, and its steps
+	// in Workflow with a "peer_" prefix on their names.
+	Peer *Incident
+}
+
+// Approval records who approved an incident. Its pre-audits ran before the
+// approval, they are not run again.
+type Approval struct {
+	By string
+	At time.Time
+	// PreAudits holds the output of each pre-audit that passed, by step name,
+	// the other pre-audits were skipped.
+	PreAudits map[string]json.RawMessage
+}
+
+// NewIncidentID returns a new random incident ID.
+func NewIncidentID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("cannot generate incident ID: %s", err))
+	}
+	return hex.EncodeToString(id)
 }
 
 // IncidentToJSON converts the incident struct into a JSON string
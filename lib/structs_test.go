@@ -0,0 +1,123 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package lib
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCommandUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Command
+		err  bool
+	}{
+		{
+			name: "arguments list",
+			json: `{"Cmd": "drain.py", "Args": ["--hostname=r1", "--reason=link flapping; rm -rf /"]}`,
+			want: Command{Cmd: "drain.py", Args: []string{"--hostname=r1", "--reason=link flapping; rm -rf /"}},
+		},
+		{
+			name: "legacy arguments string",
+			json: `{"Cmd": "drain.py", "Args": "--hostname=r1  --interface=et1"}`,
+			want: Command{Cmd: "drain.py", Args: []string{"--hostname=r1", "--interface=et1"}},
+		},
+		{
+			name: "empty legacy arguments string",
+			json: `{"Cmd": "drain.py", "Args": ""}`,
+			want: Command{Cmd: "drain.py", Args: []string{}},
+		},
+		{
+			name: "null arguments",
+			json: `{"Cmd": "drain.py", "Args": null}`,
+			want: Command{Cmd: "drain.py"},
+		},
+		{
+			name: "no arguments",
+			json: `{"Cmd": "drain.py"}`,
+			want: Command{Cmd: "drain.py"},
+		},
+		{
+			name: "arguments of another type",
+			json: `{"Cmd": "drain.py", "Args": 1}`,
+			err:  true,
+		},
+		{
+			name: "not an object",
+			json: `"drain.py"`,
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// previous arguments are replaced, not appended to
+			command := Command{Cmd: "old.py", Args: []string{"--old"}}
+			err := json.Unmarshal([]byte(test.json), &command)
+			if test.err {
+				if err == nil {
+					t.Fatalf("decoded %+v, want an error", command)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal: %s", err)
+			}
+			if command.Cmd != test.want.Cmd || len(command.Args) != len(test.want.Args) ||
+				(len(command.Args) > 0 && !reflect.DeepEqual(command.Args, test.want.Args)) {
+				t.Errorf("decoded %+v, want %+v", command, test.want)
+			}
+		})
+	}
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	command := Command{Cmd: "drain.py", Args: []string{"--description=uplink to r2", "--hostname=r1"}}
+	data, err := json.Marshal(&command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Command
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal %s: %s", data, err)
+	}
+	if !reflect.DeepEqual(decoded, command) {
+		t.Errorf("decoded %+v, want %+v", decoded, command)
+	}
+}
+
+func TestFormatArguments(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		want   []string
+	}{
+		{
+			name:   "sorted by name",
+			params: map[string]string{"interface": "et1", "hostname": "r1", "description": "uplink"},
+			want:   []string{"--description=uplink", "--hostname=r1", "--interface=et1"},
+		},
+		{
+			name:   "values kept intact",
+			params: map[string]string{"reason": "link down; $(reboot) `id` \"quoted\"", "empty": ""},
+			want:   []string{"--empty=", "--reason=link down; $(reboot) `id` \"quoted\""},
+		},
+		{
+			name: "no parameters",
+			want: []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if args := FormatArguments(test.params); !reflect.DeepEqual(args, test.want) {
+				t.Errorf("FormatArguments(%v) = %q, want %q", test.params, args, test.want)
+			}
+		})
+	}
+}
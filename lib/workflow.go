@@ -0,0 +1,76 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package lib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+)
+
+// Step is a command of an incident workflow, see confighandler.WorkflowStep.
+type Step struct {
+	Name      string
+	Phase     string
+	Command   *Command
+	DependsOn []string
+	When      *confighandler.StepCondition
+	// Timeout of the step, the one of the rule if zero.
+	Timeout time.Duration
+	// Rollback undoes the step, nil if it cannot be undone.
+	Rollback *Command
+}
+
+// WorkflowSteps returns the steps the executor has to run for the incident. Incidents
+// without a Workflow get a three stage one built from PreAudits, Remediations and
+// PostAudits: every remediation depends on all pre-audits and every post-audit on
+// all remediations, commands of a same stage running in parallel. Remediations get the
+// Rollbacks with the same index.
+func (inc *Incident) WorkflowSteps() []*Step {
+	if len(inc.Workflow) > 0 {
+		return inc.Workflow
+	}
+
+	var steps []*Step
+	names := make(map[string]bool)
+	var previous []string
+	for _, stage := range []struct {
+		phase    string
+		commands []*Command
+	}{
+		{confighandler.PhasePreAudit, inc.PreAudits},
+		{confighandler.PhaseRemediation, inc.Remediations},
+		{confighandler.PhasePostAudit, inc.PostAudits},
+	} {
+		if len(stage.commands) == 0 {
+			continue
+		}
+		current := make([]string, 0, len(stage.commands))
+		for i, command := range stage.commands {
+			name := command.Cmd
+			if names[name] {
+				name = fmt.Sprintf("%s/%s#%d", stage.phase, command.Cmd, i)
+			}
+			names[name] = true
+			current = append(current, name)
+
+			step := &Step{
+				Name:      name,
+				Phase:     stage.phase,
+				Command:   command,
+				DependsOn: previous,
+			}
+			if stage.phase == confighandler.PhaseRemediation && i < len(inc.Rollbacks) && inc.Rollbacks[i].Cmd != "" {
+				step.Rollback = inc.Rollbacks[i]
+			}
+			steps = append(steps, step)
+		}
+		previous = current
+	}
+	return steps
+}
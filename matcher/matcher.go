@@ -0,0 +1,193 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package matcher holds the log line matching logic shared by the processor
+// and the goar tooling: header parsing and first-match rule evaluation.
+package matcher
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+)
+
+// RuleSet holds a set of rules together with their compiled regular expressions.
+// Regexes[i] and ClearRegexes[i] always belong to Rules[i], ClearRegexes[i] being nil
+// for rules without ClearRegex. A RuleSet is never modified once built,
+// reloading rules builds a new one and swaps it in.
+type RuleSet struct {
+	Rules        []confighandler.Rule
+	Regexes      []*regexp.Regexp
+	ClearRegexes []*regexp.Regexp
+}
+
+// Header parses the "header" common to every log line (BASEREG in config.yaml).
+// A nil *Header or one built from an empty expression captures nothing.
+type Header struct {
+	regex *regexp.Regexp
+}
+
+// Match is the result of matching a log line against a RuleSet.
+type Match struct {
+	// Index of the matched rule in RuleSet.Rules.
+	ID   int
+	Rule confighandler.Rule
+	// Parameters captured from the line, header fields first, then the rule's captures.
+	Parameters map[string]string
+	// HeaderMatched is false when a header is configured but did not match the line.
+	HeaderMatched bool
+}
+
+// NewRuleSet validates and compiles rules. Any invalid rule fails the whole set.
+// Rules are copied, with ClearKeys defaulted for rules having a ClearRegex.
+func NewRuleSet(rules []confighandler.Rule) (*RuleSet, error) {
+	rules = append([]confighandler.Rule(nil), rules...)
+
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.RuleName == "" {
+			return nil, fmt.Errorf("rule with regex %q has no RuleName", rule.Regex)
+		}
+		if names[rule.RuleName] {
+			return nil, fmt.Errorf("duplicated rule name %s", rule.RuleName)
+		}
+		names[rule.RuleName] = true
+	}
+
+	regexList, err := compileRegexRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	clearList := make([]*regexp.Regexp, len(rules))
+	for id, rule := range rules {
+		if rule.ClearRegex == "" {
+			continue
+		}
+		if clearList[id], err = confighandler.CompileRegex(rule.ClearRegex); err != nil {
+			return nil, fmt.Errorf("unable to compile rule %s clear regex %v: %v", rule.RuleName, rule.ClearRegex, err)
+		}
+		if len(rule.ClearKeys) == 0 {
+			for _, name := range clearList[id].SubexpNames() {
+				if name != "" {
+					rules[id].ClearKeys = append(rules[id].ClearKeys, name)
+				}
+			}
+		}
+	}
+	return &RuleSet{Rules: rules, Regexes: regexList, ClearRegexes: clearList}, nil
+}
+
+// NewHeader compiles the header expression. An empty expression disables header parsing.
+func NewHeader(expr string) (*Header, error) {
+	if expr == "" {
+		return &Header{}, nil
+	}
+	rgx, err := confighandler.CompileRegex(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Header{regex: rgx}, nil
+}
+
+// Parse applies the header expression to a raw log line and returns its named
+// captures (time, ipaddress, hostname...). The returned bool is false only when
+// a header is configured and the line does not match it.
+func (header *Header) Parse(line string) (map[string]string, bool) {
+	params := make(map[string]string)
+	if header == nil || header.regex == nil {
+		return params, true
+	}
+	return params, extractParameters(header.regex, line, params)
+}
+
+// Match evaluates the rules in order and returns the first one matching line,
+// nil if none does. Only the first match counts, so rule order matters.
+func (set *RuleSet) Match(line string, header *Header) *Match {
+	for id, reg := range set.Regexes {
+		if reg.MatchString(line) {
+			// Header fields go first so rule-specific captures can override them.
+			params, headerMatched := header.Parse(line)
+			extractParameters(reg, line, params)
+
+			return &Match{
+				ID:            id,
+				Rule:          set.Rules[id],
+				Parameters:    params,
+				HeaderMatched: headerMatched,
+			}
+		}
+	}
+	return nil
+}
+
+// MatchClears returns a Match for every rule whose ClearRegex matches line,
+// i.e. every rule the line is a recovery message for.
+func (set *RuleSet) MatchClears(line string, header *Header) []*Match {
+	var matches []*Match
+	for id, reg := range set.ClearRegexes {
+		if reg == nil || !reg.MatchString(line) {
+			continue
+		}
+		params, headerMatched := header.Parse(line)
+		extractParameters(reg, line, params)
+
+		matches = append(matches, &Match{
+			ID:            id,
+			Rule:          set.Rules[id],
+			Parameters:    params,
+			HeaderMatched: headerMatched,
+		})
+	}
+	return matches
+}
+
+// MatchAll returns the index of every rule matching line, in rule order.
+// Used to find rules shadowed by earlier, broader ones.
+func (set *RuleSet) MatchAll(line string) []int {
+	var ids []int
+	for id, reg := range set.Regexes {
+		if reg.MatchString(line) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// compileRegexRules compiles regular expressions based on configured rules.
+// The returned list is aligned with rules, so any regex failing to compile is an error.
+func compileRegexRules(rules []confighandler.Rule) ([]*regexp.Regexp, error) {
+	regexList := make([]*regexp.Regexp, 0, len(rules))
+
+	for _, rule := range rules {
+		rgx, err := confighandler.CompileRegex(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("unable to compile rule %s regex %v: %v", rule.RuleName, rule.Regex, err)
+		}
+		regexList = append(regexList, rgx)
+	}
+	return regexList, nil
+}
+
+// extractParameters matches reg against msg and stores every named capture in params.
+// Unnamed groups and groups that did not take part in the match are skipped, so they
+// never overwrite a value captured earlier (e.g. by the header).
+// Returns false if reg does not match msg at all.
+func extractParameters(reg *regexp.Regexp, msg string, params map[string]string) bool {
+	indexes := reg.FindStringSubmatchIndex(msg)
+	if indexes == nil {
+		return false
+	}
+	// skip first element as it is the full line, not a split argument/parameter
+	for i, name := range reg.SubexpNames() {
+		if i == 0 || name == "" || indexes[2*i] < 0 {
+			continue
+		}
+		params[name] = msg[indexes[2*i]:indexes[2*i+1]]
+	}
+	return true
+}
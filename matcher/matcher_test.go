@@ -0,0 +1,257 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package matcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+)
+
+// testHeader is the BASEREG of config.yaml, in Python syntax.
+const testHeader = `(?<time>\w{3}\s+\d{2}\s+\d{2}\:\d{2}\:\d{2}) (?<ipaddress>\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}) (?<hostname>\w+).*`
+
+const testLine = "Mar 12 10:00:01 10.0.0.1 test_device Ebra: Line protocol on Interface Ethernet6/12/1, changed state to down"
+
+func TestNewHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		line    string
+		params  map[string]string
+		matched bool
+		err     string
+	}{
+		{
+			name: "python syntax",
+			expr: testHeader,
+			line: testLine,
+			params: map[string]string{
+				"time":      "Mar 12 10:00:01",
+				"ipaddress": "10.0.0.1",
+				"hostname":  "test_device",
+			},
+			matched: true,
+		},
+		{
+			name:    "go syntax",
+			expr:    `(?P<ipaddress>\d+\.\d+\.\d+\.\d+) (?P<hostname>\w+)`,
+			line:    testLine,
+			params:  map[string]string{"ipaddress": "10.0.0.1", "hostname": "test_device"},
+			matched: true,
+		},
+		{
+			name:    "line not matching",
+			expr:    testHeader,
+			line:    "Line protocol on Interface Ethernet6/12/1, changed state to down",
+			params:  map[string]string{},
+			matched: false,
+		},
+		{
+			name:    "empty expression",
+			expr:    "",
+			line:    testLine,
+			params:  map[string]string{},
+			matched: true,
+		},
+		{
+			name: "unbalanced group",
+			expr: `(?<time>\w{3}\s+\d{2} (?<hostname>\w+)`,
+			err:  "missing closing )",
+		},
+		{
+			name: "bad group name",
+			expr: `(?P<host-name>\w+)`,
+			err:  "invalid named capture",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header, err := NewHeader(test.expr)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("NewHeader error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewHeader: %s", err)
+			}
+			params, matched := header.Parse(test.line)
+			if matched != test.matched {
+				t.Errorf("Parse matched = %t, want %t", matched, test.matched)
+			}
+			if !reflect.DeepEqual(params, test.params) {
+				t.Errorf("Parse params = %v, want %v", params, test.params)
+			}
+		})
+	}
+}
+
+func TestNilHeader(t *testing.T) {
+	var header *Header
+	params, matched := header.Parse(testLine)
+	if !matched || len(params) != 0 {
+		t.Fatalf("nil header Parse = %v, %t, want no params and a match", params, matched)
+	}
+}
+
+func TestNewRuleSet(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     []confighandler.Rule
+		clearKeys [][]string
+		err       string
+	}{
+		{
+			name: "valid rules",
+			rules: []confighandler.Rule{
+				{RuleName: "down", Regex: `Interface (?<interface>\S+), changed state to down`},
+				{
+					RuleName:   "flap",
+					Regex:      `Interface (?P<interface>\S+) flapping`,
+					ClearRegex: `Interface (?<interface>\S+) (?:stable)`,
+				},
+				{
+					RuleName:   "bgp",
+					Regex:      `BGP peer (?P<peer>\S+) down`,
+					ClearRegex: `BGP peer (?P<peer>\S+) up`,
+					ClearKeys:  []string{"hostname", "peer"},
+				},
+			},
+			clearKeys: [][]string{nil, {"interface"}, {"hostname", "peer"}},
+		},
+		{
+			name:  "missing name",
+			rules: []confighandler.Rule{{Regex: "down"}},
+			err:   `rule with regex "down" has no RuleName`,
+		},
+		{
+			name:  "duplicated name",
+			rules: []confighandler.Rule{{RuleName: "down", Regex: "down"}, {RuleName: "down", Regex: "up"}},
+			err:   "duplicated rule name down",
+		},
+		{
+			name:  "bad regex",
+			rules: []confighandler.Rule{{RuleName: "down", Regex: `Interface (?<interface>\S+`}},
+			err:   "missing closing )",
+		},
+		{
+			name:  "bad clear regex",
+			rules: []confighandler.Rule{{RuleName: "down", Regex: "down", ClearRegex: `up[`}},
+			err:   "unable to compile rule down clear regex up[",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, err := NewRuleSet(test.rules)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("NewRuleSet error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewRuleSet: %s", err)
+			}
+			for id, rule := range set.Rules {
+				if !reflect.DeepEqual(rule.ClearKeys, test.clearKeys[id]) {
+					t.Errorf("rule %s ClearKeys = %v, want %v", rule.RuleName, rule.ClearKeys, test.clearKeys[id])
+				}
+				if (set.ClearRegexes[id] == nil) != (rule.ClearRegex == "") {
+					t.Errorf("rule %s compiled clear regex = %v", rule.RuleName, set.ClearRegexes[id])
+				}
+			}
+			if test.rules[1].ClearKeys != nil {
+				t.Errorf("NewRuleSet modified the rules it was given")
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	set, err := NewRuleSet([]confighandler.Rule{
+		{RuleName: "down", Regex: `Interface (?<interface>\S+), changed state to down`},
+		{RuleName: "any_down", Regex: `changed state to (?<state>down)`},
+		{RuleName: "override", Regex: `: (?<hostname>\w+) rebooted`},
+	})
+	if err != nil {
+		t.Fatalf("NewRuleSet: %s", err)
+	}
+	header, err := NewHeader(testHeader)
+	if err != nil {
+		t.Fatalf("NewHeader: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		line   string
+		rule   string
+		params map[string]string
+		header bool
+	}{
+		{
+			name: "first matching rule",
+			line: testLine,
+			rule: "down",
+			params: map[string]string{
+				"time":      "Mar 12 10:00:01",
+				"ipaddress": "10.0.0.1",
+				"hostname":  "test_device",
+				"interface": "Ethernet6/12/1",
+			},
+			header: true,
+		},
+		{
+			name: "rule capture over the header",
+			line: "Mar 12 10:00:01 10.0.0.1 test_device Ebra: peer_device rebooted",
+			rule: "override",
+			params: map[string]string{
+				"time":      "Mar 12 10:00:01",
+				"ipaddress": "10.0.0.1",
+				"hostname":  "peer_device",
+			},
+			header: true,
+		},
+		{
+			name:   "header not matching",
+			line:   "Ebra: router1 rebooted",
+			rule:   "override",
+			params: map[string]string{"hostname": "router1"},
+			header: false,
+		},
+		{
+			name: "no rule matching",
+			line: "Mar 12 10:00:01 10.0.0.1 test_device Ebra: all good",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match := set.Match(test.line, header)
+			if test.rule == "" {
+				if match != nil {
+					t.Fatalf("Match = rule %s, want none", match.Rule.RuleName)
+				}
+				return
+			}
+			if match == nil {
+				t.Fatalf("Match = none, want rule %s", test.rule)
+			}
+			if match.Rule.RuleName != test.rule || set.Rules[match.ID].RuleName != test.rule {
+				t.Errorf("Match rule = %s (ID %d), want %s", match.Rule.RuleName, match.ID, test.rule)
+			}
+			if !reflect.DeepEqual(match.Parameters, test.params) {
+				t.Errorf("Match params = %v, want %v", match.Parameters, test.params)
+			}
+			if match.HeaderMatched != test.header {
+				t.Errorf("Match HeaderMatched = %t, want %t", match.HeaderMatched, test.header)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// timeParameter is the header capture giving the time of a log line.
+const timeParameter = "time"
+
+// logTimeLayouts are the layouts tried, in order, to read the time of a log line.
+// time.Stamp (syslog) has no year.
+var logTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", time.Stamp}
+
+// clock gives the time to the filters: the system clock when processing the input
+// queue, the time of the log lines when replaying (see replayClock).
+type clock interface {
+	Now() time.Time
+	// AfterFunc calls f once d elapsed, unless the returned timer is stopped first.
+	AfterFunc(d time.Duration, f func()) clockTimer
+}
+
+// clockTimer is a timer set by a clock, *time.Timer for the system clock.
+type clockTimer interface {
+	Stop() bool
+}
+
+// systemClock is the clock of the processor in production.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) clockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// replayClock follows the time of the replayed lines. The timers expiring while it
+// moves forward are called in order by the goroutine moving it, so the lines must be
+// processed one at a time. It starts at the zero time, timers set before the time of
+// any line is known being moved to the first one.
+type replayClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers replayTimers
+	// seq orders the timers expiring at the same time by creation.
+	seq int
+	// untimed counts the lines without a readable time, the clock does not move for them.
+	untimed int
+}
+
+// replayTimer is a timer of a replayClock.
+type replayTimer struct {
+	clock *replayClock
+	at    time.Time
+	seq   int
+	f     func()
+	// done is set once the timer fired or was stopped.
+	done bool
+}
+
+func (c *replayClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *replayClock) AfterFunc(d time.Duration, f func()) clockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	timer := &replayTimer{clock: c, at: c.now.Add(d), seq: c.seq, f: f}
+	heap.Push(&c.timers, timer)
+	return timer
+}
+
+// Stop implements clockTimer. A stopped timer stays in the heap until it is due, then skipped.
+func (timer *replayTimer) Stop() bool {
+	timer.clock.mu.Lock()
+	defer timer.clock.mu.Unlock()
+
+	if timer.done {
+		return false
+	}
+	timer.done = true
+	return true
+}
+
+// Advance moves the clock to at, calling in order the timers expiring until then. The
+// clock never goes back: a time before the current one only fires the timers already due.
+func (c *replayClock) Advance(at time.Time) {
+	c.mu.Lock()
+	if c.now.IsZero() {
+		for _, timer := range c.timers {
+			timer.at = at.Add(timer.at.Sub(c.now))
+		}
+		c.now = at
+	}
+	c.mu.Unlock()
+
+	for c.fire(at, false) {
+	}
+
+	c.mu.Lock()
+	if at.After(c.now) {
+		c.now = at
+	}
+	c.mu.Unlock()
+}
+
+// Run calls every pending timer in order, including the ones set meanwhile, as if the
+// time went on without any more line.
+func (c *replayClock) Run() {
+	for c.fire(time.Time{}, true) {
+	}
+}
+
+// fire calls the next timer, provided it expires by until or all is set, moving the clock
+// to its time. It reports whether there was one.
+func (c *replayClock) fire(until time.Time, all bool) bool {
+	c.mu.Lock()
+	for len(c.timers) > 0 {
+		timer := c.timers[0]
+		if !all && timer.at.After(until) {
+			break
+		}
+		heap.Pop(&c.timers)
+		if timer.done {
+			continue
+		}
+		timer.done = true
+		if timer.at.After(c.now) {
+			c.now = timer.at
+		}
+		c.mu.Unlock()
+		timer.f()
+		return true
+	}
+	c.mu.Unlock()
+	return false
+}
+
+// skip records a line without a readable time.
+func (c *replayClock) skip() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.untimed++
+}
+
+// Untimed returns the number of lines the clock could not read the time of.
+func (c *replayClock) Untimed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.untimed
+}
+
+// replayTimers is a heap of timers, the next one to expire first.
+type replayTimers []*replayTimer
+
+func (timers replayTimers) Len() int { return len(timers) }
+func (timers replayTimers) Less(i, j int) bool {
+	if timers[i].at.Equal(timers[j].at) {
+		return timers[i].seq < timers[j].seq
+	}
+	return timers[i].at.Before(timers[j].at)
+}
+func (timers replayTimers) Swap(i, j int) { timers[i], timers[j] = timers[j], timers[i] }
+
+func (timers *replayTimers) Push(x interface{}) {
+	*timers = append(*timers, x.(*replayTimer))
+}
+
+func (timers *replayTimers) Pop() interface{} {
+	old := *timers
+	timer := old[len(old)-1]
+	*timers = old[:len(old)-1]
+	return timer
+}
+
+// parseLogTime reads the time of a log line. Times without a year take the one of
+// previous, the time of the line before, or the current year for the first line, and
+// the next year when they are more than half a year before previous (year change).
+func parseLogTime(value string, previous time.Time) (time.Time, error) {
+	for _, layout := range logTimeLayouts {
+		at, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		if at.Year() != 0 {
+			return at, nil
+		}
+		year := time.Now().Year()
+		if !previous.IsZero() {
+			year = previous.Year()
+		}
+		at = at.AddDate(year, 0, 0)
+		if !previous.IsZero() && previous.Sub(at) > 183*24*time.Hour {
+			at = at.AddDate(1, 0, 0)
+		}
+		return at, nil
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %q", value)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testStart is the time the clocks of the processor tests start at.
+var testStart = time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func TestParseLogTime(t *testing.T) {
+	previous := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		value    string
+		previous time.Time
+		time     time.Time
+		err      bool
+	}{
+		{"RFC 3339", "2021-06-02T10:20:30.5Z", previous, time.Date(2021, time.June, 2, 10, 20, 30, 5e8, time.UTC), false},
+		{"date and time", "2021-06-02 10:20:30", previous, time.Date(2021, time.June, 2, 10, 20, 30, 0, time.UTC), false},
+		{"syslog", "Mar  1 12:00:05", previous, time.Date(2020, time.March, 1, 12, 0, 5, 0, time.UTC), false},
+		{"syslog of the first line", "Mar  1 12:00:05", time.Time{}, time.Date(time.Now().Year(), time.March, 1, 12, 0, 5, 0, time.UTC), false},
+		{"syslog year change", "Jan  1 00:00:01", time.Date(2020, time.December, 31, 23, 59, 59, 0, time.UTC), time.Date(2021, time.January, 1, 0, 0, 1, 0, time.UTC), false},
+		{"syslog slightly out of order", "Mar  1 11:59:59", previous, time.Date(2020, time.March, 1, 11, 59, 59, 0, time.UTC), false},
+		{"unknown", "yesterday", previous, time.Time{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			at, err := parseLogTime(test.value, test.previous)
+			if (err != nil) != test.err {
+				t.Fatalf("parseLogTime(%q) error = %v, want error %t", test.value, err, test.err)
+			}
+			if !at.Equal(test.time) {
+				t.Errorf("parseLogTime(%q) = %s, want %s", test.value, at, test.time)
+			}
+		})
+	}
+}
+
+func TestReplayClock(t *testing.T) {
+	clock := &replayClock{}
+	var fired []string
+	timer := func(name string) func() {
+		return func() {
+			fired = append(fired, name+"@"+clock.Now().Sub(testStart).String())
+		}
+	}
+
+	// set before the time of any line is known
+	clock.AfterFunc(time.Second, timer("early"))
+	clock.Advance(testStart)
+	clock.AfterFunc(2*time.Second, timer("b"))
+	clock.AfterFunc(time.Second, timer("a"))
+	clock.AfterFunc(2*time.Second, timer("c"))
+	clock.AfterFunc(time.Second, timer("stopped")).Stop()
+	clock.AfterFunc(time.Second, func() {
+		// timers set by timers fire in the same pass when due
+		clock.AfterFunc(0, timer("nested"))
+	})
+
+	clock.Advance(testStart.Add(1500 * time.Millisecond))
+	if want := []string{"early@1s", "a@1s", "nested@1s"}; !reflect.DeepEqual(fired, want) {
+		t.Fatalf("fired %v, want %v", fired, want)
+	}
+	if now := clock.Now(); !now.Equal(testStart.Add(1500 * time.Millisecond)) {
+		t.Errorf("Now() = %s, want 1.5s after the start", now)
+	}
+
+	// the clock never goes back
+	clock.Advance(testStart)
+	if now := clock.Now(); !now.Equal(testStart.Add(1500 * time.Millisecond)) {
+		t.Errorf("Now() = %s once moved back, want 1.5s after the start", now)
+	}
+
+	clock.AfterFunc(time.Hour, timer("late"))
+	clock.Run()
+	if want := []string{"early@1s", "a@1s", "nested@1s", "b@2s", "c@2s", "late@1h0m1.5s"}; !reflect.DeepEqual(fired, want) {
+		t.Errorf("fired %v, want %v", fired, want)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// Incident parameters identifying the device and interface an incident is about.
+const (
+	deviceParameter    = "hostname"
+	interfaceParameter = "interface"
+	peerPrefix         = "peer_"
+)
+
+// correlator uses the topology to merge, into a single incident, the incidents of
+// both ends of a link arriving within the topology Window. It also drops incidents
+// of devices whose upstream device had an incident published in the last ActiveFor.
+type correlator struct {
+	mu       sync.Mutex
+	clock    clock
+	topology *confighandler.Topology
+	peers    map[confighandler.LinkEnd]confighandler.LinkEnd
+	pending  map[confighandler.LinkEnd]*heldIncident
+	// active maps each device with a published incident to the time it stops being active.
+	active map[string]time.Time
+}
+
+func newCorrelator(clock clock) *correlator {
+	return &correlator{
+		clock:   clock,
+		pending: make(map[confighandler.LinkEnd]*heldIncident),
+		active:  make(map[string]time.Time),
+	}
+}
+
+// SetTopology makes the correlator use topology. Without topology incidents pass through.
+func (c *correlator) SetTopology(topology *confighandler.Topology) {
+	peers := make(map[confighandler.LinkEnd]confighandler.LinkEnd, 2*len(topology.Links))
+	for _, link := range topology.Links {
+		peers[link.A] = link.B
+		peers[link.B] = link.A
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topology = topology
+	c.peers = peers
+}
+
+// Filter implements incidentFilter.
+func (c *correlator) Filter(incident lib.Incident, emit func(lib.Incident)) {
+	c.mu.Lock()
+	if c.topology == nil {
+		c.mu.Unlock()
+		emit(incident)
+		return
+	}
+	now := c.clock.Now()
+	device := incident.Parameters[deviceParameter]
+
+	if upstream := c.activeUpstream(device, now); upstream != "" {
+		c.mu.Unlock()
+		suppress(&incident, now, 0, fmt.Sprintf("upstream device %s is in an active incident", upstream))
+		return
+	}
+
+	end := confighandler.LinkEnd{Device: device, Interface: incident.Parameters[interfaceParameter]}
+	peer, ok := c.peers[end]
+	if !ok || c.topology.Window <= 0 {
+		c.markActive(incident, now)
+		c.mu.Unlock()
+		emit(incident)
+		return
+	}
+
+	if held, ok := c.pending[peer]; ok {
+		held.timer.Stop()
+		delete(c.pending, peer)
+		merged := mergeLinkIncidents(held.incident, incident)
+		c.markActive(merged, now)
+		c.mu.Unlock()
+		glog.Infof("Correlated incidents %s on %v and %s on %v", held.incident.Rule.RuleName, peer, incident.Rule.RuleName, end)
+		emit(merged)
+		return
+	}
+
+	if _, ok := c.pending[end]; ok {
+		// this end is already waiting for its peer, let suppression deal with repetitions
+		c.mu.Unlock()
+		emit(incident)
+		return
+	}
+	held := &heldIncident{incident: incident}
+	held.timer = c.clock.AfterFunc(c.topology.Window, func() {
+		c.release(end, held, emit)
+	})
+	c.pending[end] = held
+	c.mu.Unlock()
+}
+
+// release publishes an incident whose peer did not show up within the window.
+func (c *correlator) release(end confighandler.LinkEnd, held *heldIncident, emit func(lib.Incident)) {
+	c.mu.Lock()
+	if c.pending[end] != held {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.pending, end)
+	c.markActive(held.incident, c.clock.Now())
+	c.mu.Unlock()
+
+	emit(held.incident)
+}
+
+// Flush implements incidentFilter.
+func (c *correlator) Flush(emit func(lib.Incident)) {
+	var released []lib.Incident
+	c.mu.Lock()
+	for end, held := range c.pending {
+		held.timer.Stop()
+		delete(c.pending, end)
+		released = append(released, held.incident)
+	}
+	c.mu.Unlock()
+
+	for _, incident := range released {
+		emit(incident)
+	}
+}
+
+// markActive records the devices of a published incident as being in an active incident.
+// Must be called with mu held.
+func (c *correlator) markActive(incident lib.Incident, now time.Time) {
+	if c.topology.ActiveFor <= 0 {
+		return
+	}
+	until := now.Add(c.topology.ActiveFor)
+	c.active[incident.Parameters[deviceParameter]] = until
+	if incident.Peer != nil {
+		c.active[incident.Peer.Parameters[deviceParameter]] = until
+	}
+}
+
+// activeUpstream walks the upstream devices of device, returning the first one in an
+// active incident, empty string if none is. Must be called with mu held.
+func (c *correlator) activeUpstream(device string, now time.Time) string {
+	visited := map[string]bool{device: true}
+	queue := append([]string(nil), c.topology.Upstreams[device]...)
+
+	for len(queue) > 0 {
+		upstream := queue[0]
+		queue = queue[1:]
+		if visited[upstream] {
+			continue
+		}
+		visited[upstream] = true
+
+		if until, ok := c.active[upstream]; ok {
+			if now.Before(until) {
+				return upstream
+			}
+			delete(c.active, upstream)
+		}
+		queue = append(queue, c.topology.Upstreams[upstream]...)
+	}
+	return ""
+}
+
+// mergeLinkIncidents merges the incidents of both ends of a link into the first one,
+// which drives the merged incident: its ID, rule (mode, approval, retries, device lease)
+// and parameters are the ones of the first, the parameters of the second being added
+// with the peer_ prefix. The workflow runs the steps of both rules side by side, the
+// steps of the second being renamed with the peer_ prefix (see peerSteps). The commands
+// of each side get its own parameters plus the other side's ones with the peer_ prefix.
+func mergeLinkIncidents(first lib.Incident, second lib.Incident) lib.Incident {
+	merged := FormatIncident(first.Rule, peerParameters(first.Parameters, second.Parameters), first.RawIncident, first.Engine, first.CreatedAt)
+	peer := FormatIncident(second.Rule, peerParameters(second.Parameters, first.Parameters), second.RawIncident, second.Engine, second.CreatedAt)
+	merged.Workflow = append(merged.WorkflowSteps(), peerSteps(peer.WorkflowSteps(), second.Rule.Timeout)...)
+	merged.ID, merged.CreatedAt = first.ID, first.CreatedAt
+	merged.State, merged.Transitions = first.State, first.Transitions
+	merged.RawIncidents = append(append([]string(nil), first.RawIncidents...), second.RawIncidents...)
+	if len(merged.RawIncidents) == 0 {
+		merged.RawIncidents = []string{first.RawIncident, second.RawIncident}
+	}
+	merged.Peer = &second
+	return merged
+}
+
+// peerParameters returns params plus the peer parameters with the peer_ prefix.
+func peerParameters(params map[string]string, peer map[string]string) map[string]string {
+	merged := make(map[string]string, len(params)+len(peer))
+	for name, value := range params {
+		merged[name] = value
+	}
+	for name, value := range peer {
+		merged[peerPrefix+name] = value
+	}
+	return merged
+}
+
+// peerSteps returns copies of the steps of a peer incident with the peer_ prefix added
+// to their names and to the steps they refer to, and the Timeout of the peer rule set
+// on the steps without one.
+func peerSteps(steps []*lib.Step, timeout time.Duration) []*lib.Step {
+	renamed := make([]*lib.Step, 0, len(steps))
+	for _, step := range steps {
+		peer := *step
+		peer.Name = peerPrefix + step.Name
+		peer.DependsOn = nil
+		for _, dependency := range step.DependsOn {
+			peer.DependsOn = append(peer.DependsOn, peerPrefix+dependency)
+		}
+		if step.When != nil {
+			when := *step.When
+			when.Step = peerPrefix + when.Step
+			peer.When = &when
+		}
+		if peer.Timeout <= 0 {
+			peer.Timeout = timeout
+		}
+		renamed = append(renamed, &peer)
+	}
+	return renamed
+}
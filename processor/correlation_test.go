@@ -0,0 +1,188 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+var (
+	aristaDown = confighandler.Rule{
+		RuleName:     "interface_down_arista",
+		PreAudits:    []string{"check_link.py"},
+		Remediations: []string{"drain_arista.py"},
+		Timeout:      20 * time.Second,
+	}
+	junosDown = confighandler.Rule{
+		RuleName: "interface_down_junos",
+		Steps: []confighandler.WorkflowStep{
+			{Name: "drain", Cmd: "drain_junos.py", Rollback: "undrain_junos.py"},
+			{Name: "ticket", Cmd: "ticket.py", DependsOn: []string{"drain"}, When: &confighandler.StepCondition{Step: "drain", Key: "data.drained"}, Timeout: time.Minute},
+		},
+		Timeout: 40 * time.Second,
+	}
+	// testTopology links r1 et1 to r2 xe-0/0/1, r3 hanging off r2 and r4 off r3.
+	testTopology = &confighandler.Topology{
+		Window:    10 * time.Second,
+		ActiveFor: time.Minute,
+		Links: []confighandler.Link{
+			{A: confighandler.LinkEnd{Device: "r1", Interface: "et1"}, B: confighandler.LinkEnd{Device: "r2", Interface: "xe-0/0/1"}},
+		},
+		Upstreams: map[string][]string{"r3": {"r2"}, "r4": {"r3"}},
+	}
+)
+
+func TestMergeLinkIncidents(t *testing.T) {
+	first := testIncident(aristaDown, "et1 down", "hostname", "r1", "interface", "et1")
+	second := testIncident(junosDown, "xe-0/0/1 down", "hostname", "r2", "interface", "xe-0/0/1")
+	second.CreatedAt = testStart.Add(4 * time.Second)
+	merged := mergeLinkIncidents(first, second)
+
+	if merged.ID != first.ID || !merged.CreatedAt.Equal(first.CreatedAt) || merged.Rule.RuleName != aristaDown.RuleName {
+		t.Errorf("merged incident %s of %s created at %s, want the ones of the first end", merged.ID, merged.Rule.RuleName, merged.CreatedAt)
+	}
+	if merged.Peer == nil || merged.Peer.ID != second.ID || !merged.Peer.CreatedAt.Equal(second.CreatedAt) {
+		t.Errorf("merged incident peer = %+v, want the second end", merged.Peer)
+	}
+	if want := []string{"et1 down", "xe-0/0/1 down"}; !reflect.DeepEqual(merged.RawIncidents, want) {
+		t.Errorf("RawIncidents = %v, want %v", merged.RawIncidents, want)
+	}
+	wantParameters := map[string]string{"hostname": "r1", "interface": "et1", "peer_hostname": "r2", "peer_interface": "xe-0/0/1"}
+	if !reflect.DeepEqual(merged.Parameters, wantParameters) {
+		t.Errorf("Parameters = %v, want %v", merged.Parameters, wantParameters)
+	}
+
+	// each side runs its own steps with its own parameters first
+	firstArgs := []string{"--hostname=r1", "--interface=et1", "--peer_hostname=r2", "--peer_interface=xe-0/0/1"}
+	secondArgs := []string{"--hostname=r2", "--interface=xe-0/0/1", "--peer_hostname=r1", "--peer_interface=et1"}
+	wantSteps := []lib.Step{
+		{Name: "check_link.py", Phase: confighandler.PhasePreAudit, Command: &lib.Command{Cmd: "check_link.py", Args: firstArgs}},
+		{Name: "drain_arista.py", Phase: confighandler.PhaseRemediation, Command: &lib.Command{Cmd: "drain_arista.py", Args: firstArgs}, DependsOn: []string{"check_link.py"}},
+		{
+			Name: "peer_drain", Phase: confighandler.PhaseRemediation, Timeout: 40 * time.Second,
+			Command:  &lib.Command{Cmd: "drain_junos.py", Args: secondArgs},
+			Rollback: &lib.Command{Cmd: "undrain_junos.py", Args: secondArgs},
+		},
+		{
+			Name: "peer_ticket", Phase: confighandler.PhaseRemediation, Timeout: time.Minute,
+			Command:   &lib.Command{Cmd: "ticket.py", Args: secondArgs},
+			DependsOn: []string{"peer_drain"},
+			When:      &confighandler.StepCondition{Step: "peer_drain", Key: "data.drained"},
+		},
+	}
+	if len(merged.Workflow) != len(wantSteps) {
+		t.Fatalf("merged workflow has %d steps, want %d", len(merged.Workflow), len(wantSteps))
+	}
+	for i, step := range merged.Workflow {
+		if !reflect.DeepEqual(*step, wantSteps[i]) {
+			t.Errorf("step %d = %+v, want %+v", i, *step, wantSteps[i])
+		}
+	}
+
+	// the rule of the peer still refers to its own step names
+	if when := junosDown.Steps[1].When; when.Step != "drain" {
+		t.Errorf("merging renamed the step condition of the peer rule to %s", when.Step)
+	}
+}
+
+func TestCorrelatorMerge(t *testing.T) {
+	clock := newTestClock()
+	correlator := newCorrelator(clock)
+	correlator.SetTopology(testTopology)
+	output := &emitted{clock: clock}
+
+	// r1 waits for its peer, which shows up within the window
+	correlator.Filter(testIncident(aristaDown, "et1 down", "hostname", "r1", "interface", "et1"), output.emit)
+	if len(output.incidents) != 0 {
+		t.Fatalf("incident of a link end emitted before the window ended")
+	}
+	at(clock, 4*time.Second)
+	correlator.Filter(testIncident(junosDown, "xe-0/0/1 down", "hostname", "r2", "interface", "xe-0/0/1"), output.emit)
+	if len(output.incidents) != 1 || output.incidents[0].Peer == nil || output.incidents[0].Peer.RawIncident != "xe-0/0/1 down" {
+		t.Fatalf("emitted %+v, want the merged incident right away", output.incidents)
+	}
+
+	// interfaces not on a link are not held
+	at(clock, 5*time.Second)
+	correlator.Filter(testIncident(aristaDown, "et9 down", "hostname", "r1", "interface", "et9"), output.emit)
+
+	// a peer arriving after the window gets an incident of its own
+	at(clock, 2*time.Minute)
+	correlator.Filter(testIncident(junosDown, "xe-0/0/1 down again", "hostname", "r2", "interface", "xe-0/0/1"), output.emit)
+	at(clock, 2*time.Minute+time.Second)
+	// a repetition of the held end passes through, the held incident stays
+	correlator.Filter(testIncident(junosDown, "xe-0/0/1 still down", "hostname", "r2", "interface", "xe-0/0/1"), output.emit)
+	at(clock, 2*time.Minute+11*time.Second)
+	correlator.Filter(testIncident(aristaDown, "et1 down again", "hostname", "r1", "interface", "et1"), output.emit)
+	// the last one is flushed, its timer stopped
+	correlator.Flush(output.emit)
+	clock.Run()
+
+	var got []string
+	for i, incident := range output.incidents[1:] {
+		if incident.Peer != nil {
+			t.Errorf("incident %s merged with %s", incident.RawIncident, incident.Peer.RawIncident)
+		}
+		got = append(got, output.after[i+1].String()+" "+incident.RawIncident)
+	}
+	want := []string{"5s et9 down", "2m1s xe-0/0/1 still down", "2m10s xe-0/0/1 down again", "2m11s et1 down again"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("emitted %v, want %v", got, want)
+	}
+}
+
+func TestCorrelatorUpstream(t *testing.T) {
+	clock := newTestClock()
+	correlator := newCorrelator(clock)
+	correlator.SetTopology(testTopology)
+	output := &emitted{clock: clock}
+	reboot := confighandler.Rule{RuleName: "reboot"}
+
+	// the merged link incident makes both r1 and r2 active for a minute
+	correlator.Filter(testIncident(aristaDown, "et1 down", "hostname", "r1", "interface", "et1"), output.emit)
+	correlator.Filter(testIncident(junosDown, "xe-0/0/1 down", "hostname", "r2", "interface", "xe-0/0/1"), output.emit)
+
+	for _, line := range []struct {
+		after    time.Duration
+		hostname string
+		emitted  bool
+	}{
+		// r3 hangs off r2, r4 off r3, all within the minute r2 is active
+		{10 * time.Second, "r3", false},
+		{20 * time.Second, "r4", false},
+		// an active device is not downstream of itself
+		{30 * time.Second, "r2", true},
+		// r2 was active again from 30s
+		{80 * time.Second, "r3", false},
+		{91 * time.Second, "r4", true},
+	} {
+		at(clock, line.after)
+		before := len(output.incidents)
+		incident := testIncident(reboot, "reboot", "hostname", line.hostname)
+		correlator.Filter(incident, output.emit)
+		if emitted := len(output.incidents) > before; emitted != line.emitted {
+			t.Errorf("incident of %s at %s emitted = %t, want %t", line.hostname, line.after, emitted, line.emitted)
+		}
+	}
+}
+
+func TestCorrelatorWithoutTopology(t *testing.T) {
+	clock := newTestClock()
+	correlator := newCorrelator(clock)
+	output := &emitted{clock: clock}
+
+	correlator.Filter(testIncident(aristaDown, "et1 down", "hostname", "r1", "interface", "et1"), output.emit)
+	correlator.Filter(testIncident(junosDown, "xe-0/0/1 down", "hostname", "r2", "interface", "xe-0/0/1"), output.emit)
+	if len(output.incidents) != 2 || output.incidents[0].Peer != nil || output.incidents[1].Peer != nil {
+		t.Errorf("emitted %+v, want both incidents as they are", output.incidents)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// incidentFilter sits between the rules and the publisher. It receives every incident
+// created by the rules and forwards incidents through emit: right away, later, merged
+// with others or not at all.
+type incidentFilter interface {
+	Filter(incident lib.Incident, emit func(lib.Incident))
+	// Flush emits right away every incident the filter still holds.
+	// Used when the input ends, e.g. at the end of a replay.
+	Flush(emit func(lib.Incident))
+}
+
+// dispatch runs an incident through the filters, in order, and sends whatever
+// comes out of the last one to the IncidentChannel.
+func (processor *Processor) dispatch(incident lib.Incident) {
+	processor.filterFrom(0, incident)
+}
+
+func (processor *Processor) filterFrom(index int, incident lib.Incident) {
+	if index == len(processor.filters) {
+		processor.IncidentChannel <- incident
+		return
+	}
+	processor.filters[index].Filter(incident, processor.emitter(index+1))
+}
+
+// emitter returns the function forwarding incidents to the filter at index.
+func (processor *Processor) emitter(index int) func(lib.Incident) {
+	return func(incident lib.Incident) {
+		processor.filterFrom(index, incident)
+	}
+}
+
+// flushFilters flushes the filters in order, so incidents released by one
+// filter can still be held and then flushed by the following ones.
+func (processor *Processor) flushFilters() {
+	for index, filter := range processor.filters {
+		filter.Flush(processor.emitter(index + 1))
+	}
+}
+
+// incidentKey identifies an incident by its rule name and the values of
+// the given parameters, e.g. hostname plus interface.
+func incidentKey(incident lib.Incident, params []string) string {
+	return parametersKey(incident.Rule.RuleName, incident.Parameters, params)
+}
+
+// parametersKey joins a rule name and the values of the given parameters.
+func parametersKey(ruleName string, values map[string]string, params []string) string {
+	parts := make([]string, 0, len(params)+1)
+	parts = append(parts, ruleName)
+	for _, param := range params {
+		parts = append(parts, values[param])
+	}
+	return strings.Join(parts, "\x00")
+}
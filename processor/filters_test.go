@@ -0,0 +1,77 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// newTestClock returns a replay clock at testStart, moved by the tests with at.
+func newTestClock() *replayClock {
+	clock := &replayClock{}
+	clock.Advance(testStart)
+	return clock
+}
+
+// at moves clock to after the start of the test, calling the timers expiring meanwhile.
+func at(clock *replayClock, after time.Duration) {
+	clock.Advance(testStart.Add(after))
+}
+
+// testIncident returns an incident of rule detected at testStart for the given
+// parameter name and value pairs.
+func testIncident(rule confighandler.Rule, raw string, params ...string) lib.Incident {
+	parameters := make(map[string]string, len(params)/2)
+	for i := 0; i+1 < len(params); i += 2 {
+		parameters[params[i]] = params[i+1]
+	}
+	return FormatIncident(rule, parameters, raw, "test", testStart)
+}
+
+// emitted records the incidents emitted by a filter, with the time they were emitted at.
+type emitted struct {
+	mu        sync.Mutex
+	clock     clock
+	incidents []lib.Incident
+	// after is the time of each incident after the start of the test.
+	after []time.Duration
+}
+
+func (e *emitted) emit(incident lib.Incident) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.incidents = append(e.incidents, incident)
+	e.after = append(e.after, e.clock.Now().Sub(testStart))
+}
+
+func TestIncidentKey(t *testing.T) {
+	rule := confighandler.Rule{RuleName: "rule"}
+	tests := []struct {
+		name   string
+		a, b   lib.Incident
+		params []string
+		same   bool
+	}{
+		{"same values", testIncident(rule, "", "hostname", "r1", "interface", "et1"), testIncident(rule, "", "hostname", "r1", "interface", "et2"), []string{"hostname"}, true},
+		{"different values", testIncident(rule, "", "hostname", "r1"), testIncident(rule, "", "hostname", "r2"), []string{"hostname"}, false},
+		{"no parameters", testIncident(rule, "", "hostname", "r1"), testIncident(rule, "", "hostname", "r2"), nil, true},
+		{"different rules", testIncident(rule, "", "hostname", "r1"), testIncident(confighandler.Rule{RuleName: "other"}, "", "hostname", "r1"), []string{"hostname"}, false},
+		{"values not mixed up", testIncident(rule, "", "a", "x", "b", ""), testIncident(rule, "", "a", "", "b", "x"), []string{"a", "b"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := incidentKey(test.a, test.params) == incidentKey(test.b, test.params); same != test.same {
+				t.Errorf("same key = %t, want %t", same, test.same)
+			}
+		})
+	}
+}
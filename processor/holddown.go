@@ -0,0 +1,115 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/facebookexperimental/GOAR/lib"
+	"github.com/facebookexperimental/GOAR/matcher"
+)
+
+// holdDown delays the incidents of rules with a HoldDown. If a line matching the
+// rule ClearRegex arrives meanwhile for the same ClearKeys values, e.g. the interface
+// came back up, the pending incidents are dropped and counted as auto-cleared.
+type holdDown struct {
+	mu      sync.Mutex
+	clock   clock
+	pending map[string][]*heldIncident
+
+	autoCleared uint64
+}
+
+// heldIncident is an incident waiting for its hold-down to expire.
+type heldIncident struct {
+	incident lib.Incident
+	timer    clockTimer
+}
+
+func newHoldDown(clock clock) *holdDown {
+	return &holdDown{clock: clock, pending: make(map[string][]*heldIncident)}
+}
+
+// Filter implements incidentFilter.
+func (h *holdDown) Filter(incident lib.Incident, emit func(lib.Incident)) {
+	if incident.Rule.HoldDown <= 0 {
+		emit(incident)
+		return
+	}
+	key := incidentKey(incident, incident.Rule.ClearKeys)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	held := &heldIncident{incident: incident}
+	held.timer = h.clock.AfterFunc(incident.Rule.HoldDown, func() {
+		h.release(key, held, emit)
+	})
+	h.pending[key] = append(h.pending[key], held)
+}
+
+// Clear drops the incidents held for the rule and parameters of a clear line.
+func (h *holdDown) Clear(match *matcher.Match) {
+	key := parametersKey(match.Rule.RuleName, match.Parameters, match.Rule.ClearKeys)
+
+	now := h.clock.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, held := range h.pending[key] {
+		held.timer.Stop()
+		atomic.AddUint64(&h.autoCleared, 1)
+		suppress(&held.incident, now, 0, "auto-cleared during its hold-down: "+held.incident.RawIncident)
+	}
+	delete(h.pending, key)
+}
+
+// AutoCleared returns the number of incidents dropped by a clear line so far.
+func (h *holdDown) AutoCleared() uint64 {
+	return atomic.LoadUint64(&h.autoCleared)
+}
+
+// release publishes a held incident once its hold-down expired, unless it was cleared or flushed.
+func (h *holdDown) release(key string, held *heldIncident, emit func(lib.Incident)) {
+	h.mu.Lock()
+	pending := h.pending[key]
+	for i, candidate := range pending {
+		if candidate != held {
+			continue
+		}
+		if len(pending) == 1 {
+			delete(h.pending, key)
+		} else {
+			h.pending[key] = append(pending[:i:i], pending[i+1:]...)
+		}
+		h.mu.Unlock()
+		// the next filters may block, never emit with mu held
+		emit(held.incident)
+		return
+	}
+	h.mu.Unlock()
+}
+
+// Flush implements incidentFilter.
+func (h *holdDown) Flush(emit func(lib.Incident)) {
+	var released []lib.Incident
+	h.mu.Lock()
+	for key, pending := range h.pending {
+		for _, held := range pending {
+			held.timer.Stop()
+			released = append(released, held.incident)
+		}
+		delete(h.pending, key)
+	}
+	h.mu.Unlock()
+
+	for _, incident := range released {
+		emit(incident)
+	}
+}
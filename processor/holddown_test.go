@@ -0,0 +1,145 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+	"github.com/facebookexperimental/GOAR/matcher"
+)
+
+// interfaceDown is a rule held down for 30s and cleared per interface.
+var interfaceDown = confighandler.Rule{
+	RuleName:  "interface_down",
+	HoldDown:  30 * time.Second,
+	ClearKeys: []string{"hostname", "interface"},
+}
+
+// released maps the raw line of each incident emitted to when it was.
+func released(output *emitted) map[string]time.Duration {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	released := make(map[string]time.Duration, len(output.incidents))
+	for i, incident := range output.incidents {
+		released[incident.RawIncident] = output.after[i]
+	}
+	return released
+}
+
+// clearLine is the match of a clear line of interfaceDown.
+func clearLine(hostname, iface string) *matcher.Match {
+	return &matcher.Match{Rule: interfaceDown, Parameters: map[string]string{"hostname": hostname, "interface": iface}}
+}
+
+func TestHoldDownRelease(t *testing.T) {
+	clock := newTestClock()
+	holdDown := newHoldDown(clock)
+	output := &emitted{clock: clock}
+
+	holdDown.Filter(testIncident(confighandler.Rule{RuleName: "reboot"}, "reboot", "hostname", "r1"), output.emit)
+	holdDown.Filter(testIncident(interfaceDown, "a", "hostname", "r1", "interface", "et1"), output.emit)
+	at(clock, 10*time.Second)
+	holdDown.Filter(testIncident(interfaceDown, "b", "hostname", "r1", "interface", "et1"), output.emit)
+
+	at(clock, 29*time.Second)
+	if want := map[string]time.Duration{"reboot": 0}; !reflect.DeepEqual(released(output), want) {
+		t.Fatalf("emitted %v during the hold-down, want only the incident of the rule without one", released(output))
+	}
+	clock.Run()
+	want := map[string]time.Duration{"reboot": 0, "a": 30 * time.Second, "b": 40 * time.Second}
+	if !reflect.DeepEqual(released(output), want) {
+		t.Errorf("emitted %v, want %v", released(output), want)
+	}
+	if len(holdDown.pending) != 0 || holdDown.AutoCleared() != 0 {
+		t.Errorf("%d key(s) pending and %d auto-cleared once released, want none", len(holdDown.pending), holdDown.AutoCleared())
+	}
+}
+
+func TestHoldDownClear(t *testing.T) {
+	clock := newTestClock()
+	holdDown := newHoldDown(clock)
+	output := &emitted{clock: clock}
+
+	holdDown.Filter(testIncident(interfaceDown, "et1 down", "hostname", "r1", "interface", "et1"), output.emit)
+	holdDown.Filter(testIncident(interfaceDown, "et1 down again", "hostname", "r1", "interface", "et1"), output.emit)
+	holdDown.Filter(testIncident(interfaceDown, "et2 down", "hostname", "r1", "interface", "et2"), output.emit)
+	holdDown.Filter(testIncident(interfaceDown, "r2 et1 down", "hostname", "r2", "interface", "et1"), output.emit)
+
+	// the interface came back up: both of its incidents are dropped, the others are not
+	at(clock, 10*time.Second)
+	holdDown.Clear(clearLine("r1", "et1"))
+	if cleared := holdDown.AutoCleared(); cleared != 2 {
+		t.Errorf("AutoCleared() = %d after the clear, want 2", cleared)
+	}
+
+	// a new incident of the cleared interface is held again
+	at(clock, 20*time.Second)
+	holdDown.Filter(testIncident(interfaceDown, "et1 down once more", "hostname", "r1", "interface", "et1"), output.emit)
+
+	// a clear arriving once the incident was released changes nothing
+	at(clock, 31*time.Second)
+	holdDown.Clear(clearLine("r1", "et2"))
+	clock.Run()
+
+	want := map[string]time.Duration{"et2 down": 30 * time.Second, "r2 et1 down": 30 * time.Second, "et1 down once more": 50 * time.Second}
+	if !reflect.DeepEqual(released(output), want) {
+		t.Errorf("emitted %v, want %v", released(output), want)
+	}
+	if cleared := holdDown.AutoCleared(); cleared != 2 {
+		t.Errorf("AutoCleared() = %d, want 2", cleared)
+	}
+}
+
+func TestHoldDownEmitUnlocked(t *testing.T) {
+	clock := newTestClock()
+	holdDown := newHoldDown(clock)
+	output := &emitted{clock: clock}
+
+	// the next filters may feed the hold-down again, e.g. through a clear line
+	emit := func(incident lib.Incident) {
+		holdDown.Clear(clearLine("r1", "et2"))
+		output.emit(incident)
+	}
+	holdDown.Filter(testIncident(interfaceDown, "a", "hostname", "r1", "interface", "et1"), emit)
+
+	done := make(chan struct{})
+	go func() {
+		clock.Run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("release blocked, the incident was emitted with the hold-down locked")
+	}
+	if want := map[string]time.Duration{"a": 30 * time.Second}; !reflect.DeepEqual(released(output), want) {
+		t.Errorf("emitted %v, want %v", released(output), want)
+	}
+}
+
+func TestHoldDownFlush(t *testing.T) {
+	clock := newTestClock()
+	holdDown := newHoldDown(clock)
+	output := &emitted{clock: clock}
+
+	holdDown.Filter(testIncident(interfaceDown, "a", "hostname", "r1", "interface", "et1"), output.emit)
+	at(clock, 10*time.Second)
+	holdDown.Flush(output.emit)
+	// the timer of the flushed incident was stopped
+	clock.Run()
+
+	if want := map[string]time.Duration{"a": 10 * time.Second}; !reflect.DeepEqual(released(output), want) || len(output.incidents) != 1 {
+		t.Errorf("emitted %v, want a once, when flushed", released(output))
+	}
+	if len(holdDown.pending) != 0 {
+		t.Errorf("%d key(s) pending once flushed", len(holdDown.pending))
+	}
+}
@@ -7,9 +7,7 @@
 package main
 
 import (
-	"fmt"
-	"regexp"
-	"strings"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/facebookexperimental/GOAR/confighandler"
@@ -17,27 +15,15 @@ import (
 	"github.com/golang/glog"
 )
 
-// compileRegexRules compiles regular expressions based on configured rules.
-func compileRegexRules(rules []confighandler.Rule) []*regexp.Regexp {
-	regexList := make([]*regexp.Regexp, 0, len(rules))
-
-	for _, rule := range rules {
-		rgx, err := regexp.Compile(rule.Regex)
-		if err != nil {
-			glog.Errorf("Unable to compile %v regex %v\n", rule.Regex, err)
-			continue
-		}
-		regexList = append(regexList, rgx)
-	}
-	return regexList
-}
-
 // FormatIncident returns an instance of Incident struct build upon rules, parameters, input message as well as engine
 // With rule, parameters gathered, raw message and engine used to detect an 'incident'
 // we create an Incident struct with all that information, and return it back to the caller.
-func FormatIncident(rule confighandler.Rule, params map[string]string, msg string, engine string) lib.Incident {
+// at is the time of detection, from the clock of the processor.
+func FormatIncident(rule confighandler.Rule, params map[string]string, msg string, engine string, at time.Time) lib.Incident {
 
 	incident := lib.Incident{
+		ID:        lib.NewIncidentID(),
+		CreatedAt: at,
 		// Rule and RawIncident are mostly useful for troubleshooting
 		// This will be used intensively in our future elastic logging
 		Rule:        rule,   // Rule that triggered the event.
@@ -46,11 +32,14 @@ func FormatIncident(rule confighandler.Rule, params map[string]string, msg strin
 		Engine:      engine, // Engine that catched the event. Syslog, event, etc
 	}
 
-	parameters := formatCommandArguments(&params)
+	parameters := lib.FormatArguments(params)
 
 	incident.PreAudits = formatCommand(&rule.PreAudits, parameters)
 	incident.Remediations = formatCommand(&rule.Remediations, parameters)
 	incident.PostAudits = formatCommand(&rule.PostAudits, parameters)
+	incident.Rollbacks = formatCommand(&rule.Rollbacks, parameters)
+	incident.Workflow = formatWorkflow(rule.Steps, parameters)
+	incident.TransitionAt(lib.StateDetected, at)
 
 	if glog.V(2) {
 		glog.Infof("Formatted incident: %v", spew.Sdump(incident))
@@ -58,22 +47,51 @@ func FormatIncident(rule confighandler.Rule, params map[string]string, msg strin
 	return incident
 }
 
-func formatCommandArguments(params *map[string]string) *string {
-	arguments := make([]string, 0, len(*params))
-	for param, value := range *params {
-		arguments = append(arguments, fmt.Sprintf("--%s=%s", param, value))
+// suppress moves an incident dropped by the processor to the suppressed state, which
+// ends its lifecycle at the time at, and logs it at verbosity level with the reason it
+// was dropped. The log line is the only trace of the incident, which is never published.
+func suppress(incident *lib.Incident, at time.Time, level glog.Level, reason string) {
+	if err := incident.TransitionAt(lib.StateSuppressed, at); err != nil {
+		glog.Warning(err)
+	}
+	if glog.V(level) {
+		glog.Infof("Incident %s of rule %s for %v %s: %s", incident.ID, incident.Rule.RuleName, incident.Parameters, incident.State, reason)
 	}
-	result := strings.Join(arguments, " ")
-	return &result
 }
 
-func formatCommand(commands *[]string, parameters *string) []*lib.Command {
+func formatCommand(commands *[]string, parameters []string) []*lib.Command {
 
 	incidentCommands := make([]*lib.Command, 0, len(*commands))
 
 	for _, command := range *commands {
-		incidentCommands = append(incidentCommands, &lib.Command{Cmd: command, Args: *parameters})
+		incidentCommands = append(incidentCommands, &lib.Command{Cmd: command, Args: parameters})
 	}
 
 	return incidentCommands
 }
+
+func formatWorkflow(steps []confighandler.WorkflowStep, parameters []string) []*lib.Step {
+
+	workflow := make([]*lib.Step, 0, len(steps))
+
+	for _, step := range steps {
+		phase := step.Phase
+		if phase == "" {
+			phase = confighandler.PhaseRemediation
+		}
+		incidentStep := &lib.Step{
+			Name:      step.Name,
+			Phase:     phase,
+			Command:   &lib.Command{Cmd: step.Cmd, Args: parameters},
+			DependsOn: step.DependsOn,
+			When:      step.When,
+			Timeout:   step.Timeout,
+		}
+		if step.Rollback != "" {
+			incidentStep.Rollback = &lib.Command{Cmd: step.Rollback, Args: parameters}
+		}
+		workflow = append(workflow, incidentStep)
+	}
+
+	return workflow
+}
@@ -8,8 +8,9 @@ package main
 
 import (
 	"flag"
-
-	"github.com/davecgh/go-spew/spew"
+	"io"
+	"os"
+	"time"
 
 	"github.com/facebookexperimental/GOAR/confighandler"
 	"github.com/golang/glog"
@@ -20,6 +21,16 @@ const (
 	blockingMode     bool = true
 )
 
+var rulesPollInterval = flag.Duration("rules_poll_interval",
+	5*time.Second,
+	"How often the rules file is checked for changes to reload it")
+var replayInput = flag.String("replay",
+	"",
+	"Dry-run over a log file ('-' for stdin) instead of the input queue, incidents are written instead of published")
+var replayOutput = flag.String("replay_output",
+	"-",
+	"File where replayed incidents are written as JSON lines, '-' for stdout")
+
 func main() {
 	flag.Parse()
 
@@ -30,20 +41,62 @@ func main() {
 		glog.Exitf("Error opening config %s\n", err)
 	}
 
-	glog.Infoln("[*] Connection to queue server open")
-	rules, err := confighandler.GetRules(conf.RulesFile)
-
-	if err != nil {
+	processor := NewProcessor()
+	if *replayInput != "" {
+		processor = NewReplayProcessor()
+	}
+	if err := processor.LoadRules(conf.RulesFile, conf.WorkflowsFile); err != nil {
 		glog.Exitf("Error reading/parsing rules %s\n", err)
 	}
+	if err := processor.SetBaseRegex(conf.BaseReg); err != nil {
+		glog.Exitf("Error compiling BASEREG header regex %q: %v\n", conf.BaseReg, err)
+	}
+	processor.SetRateLimits(conf)
+	if conf.TopologyFile != "" {
+		if err := processor.SetTopology(conf.TopologyFile); err != nil {
+			glog.Exitf("Error reading/parsing topology %s\n", err)
+		}
+	}
 
-	if glog.V(2) {
-		glog.Infof("Read rules defined on %s: %v", conf.RulesFile, spew.Sdump(rules))
+	if *replayInput != "" {
+		replay(processor, *replayInput, *replayOutput)
+		return
 	}
+	processor.WatchRules(conf.RulesFile, conf.WorkflowsFile, *rulesPollInterval)
 
-	processor := NewProcessor()
+	glog.Infoln("[*] Connection to queue server open")
 	if err := processor.Connect(conf); err != nil {
 		glog.Exitf("Error connecting, %v\n", err)
 	}
-	processor.Run(rules, blockingMode)
+	processor.Run(blockingMode)
+}
+
+// replay runs the processor over a historical log instead of the input queue
+// and prints a per rule summary of the incidents it would have created.
+func replay(processor *Processor, inputPath string, outputPath string) {
+	var in io.Reader = os.Stdin
+	if inputPath != "-" {
+		file, err := os.Open(inputPath)
+		if err != nil {
+			glog.Exitf("Error opening replay input %s\n", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	var out io.Writer = os.Stdout
+	if outputPath != "-" {
+		file, err := os.Create(outputPath)
+		if err != nil {
+			glog.Exitf("Error creating replay output %s\n", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	summary, err := processor.Replay(in, out)
+	if err != nil {
+		glog.Errorf("Error during replay: %s", err)
+	}
+	summary.Print(os.Stderr)
 }
@@ -7,10 +7,11 @@
 package main
 
 import (
-	"math"
 	"sync"
+	"sync/atomic"
 
 	"github.com/facebookexperimental/GOAR/endpoints"
+	"github.com/facebookexperimental/GOAR/matcher"
 
 	"github.com/golang/glog"
 
@@ -64,6 +65,8 @@ func (endpoint *InputEndpoint) Connect(conf confighandler.Config) error {
 // maintaining connection with the output external queue
 type OutputEndpoint struct {
 	AmqpQueue amqp.Queue
+	// Queue receiving the incidents over a rate limit and the alerts of the limits tripped
+	RateLimitedQueue amqp.Queue
 	endpoints.RabbitMQEndpoint
 }
 
@@ -84,6 +87,18 @@ func (endpoint *OutputEndpoint) Connect(conf confighandler.Config) error {
 		false,              // no-wait
 		nil,                // arguments
 	)
+	if err != nil {
+		return err
+	}
+
+	endpoint.RateLimitedQueue, err = endpoint.Channel.QueueDeclare(
+		conf.QueueRateLimited, // name
+		true,                  // durable
+		false,                 // delete when unused
+		false,                 // exclusive
+		false,                 // no-wait
+		nil,                   // arguments
+	)
 	return err
 
 }
@@ -97,7 +112,30 @@ type Processor struct {
 
 	RawLogChannel   chan []byte
 	IncidentChannel chan lib.Incident
-
+	// RateLimitedChannel receives the incidents over a rate limit and the alerts of the limits tripped
+	RateLimitedChannel chan lib.Incident
+
+	// header parses the header common to every log line (BASEREG).
+	header *matcher.Header
+	// ruleSet holds the *matcher.RuleSet in use, replaced as a whole on reload.
+	ruleSet atomic.Value
+	// filters applied, in order, to incidents before publishing them.
+	filters []incidentFilter
+	// holdDown is also one of the filters, clear lines are fed to it directly.
+	holdDown *holdDown
+	// correlator is also one of the filters, it needs the topology to be set.
+	correlator *correlator
+	// suppressor is also one of the filters, counting the incidents it suppressed.
+	suppressor *suppressor
+	// rateLimiter is also one of the filters, global and DeviceType limits are set on it.
+	rateLimiter *rateLimiter
+	// clock gives the time of detection of the incidents, shared with the filters.
+	clock clock
+	// replayClock is the clock of the filters of a replay processor, nil otherwise.
+	replayClock *replayClock
+	// onMatch, if set, is called for every line matching a rule, before its incident
+	// goes through the filters. Replay counts the hits of the rules with it.
+	onMatch         func(match *matcher.Match)
 	eventProcessors int
 }
 
@@ -114,24 +152,83 @@ func (processor *Processor) Connect(conf confighandler.Config) error {
 
 // NewProcessor configures and sets Processor object.
 func NewProcessor() *Processor {
-	return &Processor{
-		eventProcessors: defaultProcessorsNum,
-		RawLogChannel:   make(chan []byte),
-		IncidentChannel: make(chan lib.Incident),
+	return newProcessor(systemClock{})
+}
+
+// NewReplayProcessor returns a Processor for Replay. Lines are processed one at a time,
+// in order, and the time windows of the filters follow the time of the lines
+// (the time capture of BASEREG) instead of the system clock.
+func NewReplayProcessor() *Processor {
+	clock := &replayClock{}
+	processor := newProcessor(clock)
+	processor.replayClock = clock
+	processor.eventProcessors = 1
+	return processor
+}
+
+// newProcessor returns a Processor whose filters use clock.
+func newProcessor(clock clock) *Processor {
+	processor := &Processor{
+		clock:              clock,
+		eventProcessors:    defaultProcessorsNum,
+		RawLogChannel:      make(chan []byte),
+		IncidentChannel:    make(chan lib.Incident),
+		RateLimitedChannel: make(chan lib.Incident),
+		holdDown:           newHoldDown(clock),
+		correlator:         newCorrelator(clock),
+		suppressor:         newSuppressor(clock),
+	}
+	processor.rateLimiter = newRateLimiter(clock, func(incident lib.Incident) {
+		processor.RateLimitedChannel <- incident
+	})
+	processor.filters = []incidentFilter{
+		newThresholdCounter(clock),
+		processor.holdDown,
+		processor.correlator,
+		processor.suppressor,
+		processor.rateLimiter,
+	}
+	return processor
+}
+
+// SetBaseRegex compiles the BASEREG header expression applied to every log line
+// before the rules. An empty expression disables header parsing.
+func (processor *Processor) SetBaseRegex(expr string) error {
+	header, err := matcher.NewHeader(expr)
+	if err != nil {
+		return err
+	}
+	processor.header = header
+	return nil
+}
+
+// SetTopology enables correlation of incidents using the topology read from path.
+func (processor *Processor) SetTopology(path string) error {
+	topology, err := confighandler.GetTopology(path)
+	if err != nil {
+		return err
 	}
+	processor.correlator.SetTopology(&topology)
+	return nil
+}
+
+// SetRateLimits configures the global and per DeviceType incident rate limits,
+// per rule limits being defined in the rules.
+func (processor *Processor) SetRateLimits(conf confighandler.Config) {
+	processor.rateLimiter.SetLimits(conf.GlobalRateLimit, conf.DeviceTypeRateLimits)
 }
 
 // Run runs all the pieces, listening for logs in the input queue, applying
-// rules passed as an argument and publishes incidents to output external queue
-func (processor *Processor) Run(rules []confighandler.Rule, blocking bool) {
+// rules loaded with LoadRules and publishes incidents to output external queue
+func (processor *Processor) Run(blocking bool) {
 
 	processor.tailInput()
 	processor.publishIncidents()
 
 	if blocking {
-		processor.processEvents(rules)
+		processor.processEvents()
 	} else {
-		go processor.processEvents(rules)
+		go processor.processEvents()
 	}
 
 }
@@ -149,9 +246,7 @@ func (processor *Processor) tailInput() {
 
 }
 
-func (processor *Processor) processEvents(rules []confighandler.Rule) {
-
-	regexList := compileRegexRules(rules)
+func (processor *Processor) processEvents() {
 
 	var wg sync.WaitGroup
 	wg.Add(processor.eventProcessors)
@@ -161,27 +256,26 @@ func (processor *Processor) processEvents(rules []confighandler.Rule) {
 			defer wg.Done()
 			for msg := range processor.RawLogChannel {
 				msgStr := string(msg)
-				for id, reg := range regexList {
-					if reg.MatchString(msgStr) {
-
-						eventParameters := make(map[string]string)
-
-						values := reg.FindStringSubmatch(msgStr)
-						regexNames := reg.SubexpNames()
-						length := int(math.Min(float64(len(values)), float64(len(regexNames))))
-						// skip first element in both arrays as these are full lines, not split arguments/parameters
-						for i := 1; i < length; i++ {
-							eventParameters[regexNames[i]] = values[i]
-						}
-
-						processor.IncidentChannel <- FormatIncident(rules[id], eventParameters, msgStr, "SYSLOGPROC")
-						// As soon as we manage to create an incident from matching
-						// any of the rules we skip to the next possible regex.
-						// that way we avoid too much processing and also creating multiple
-						// incidents from a single syslog line.
-						break
-					}
+				processor.advanceClock(msgStr)
+				// Only the first matching rule creates an incident, that way we avoid
+				// too much processing and also creating multiple incidents from a single
+				// syslog line. Rules are loaded once per line, so a reload never mixes two sets.
+				ruleSet := processor.currentRules()
+				for _, clear := range ruleSet.MatchClears(msgStr, processor.header) {
+					processor.holdDown.Clear(clear)
 				}
+
+				match := ruleSet.Match(msgStr, processor.header)
+				if match == nil {
+					continue
+				}
+				if !match.HeaderMatched {
+					glog.Warningf("BASEREG header regex did not match, incident will carry no header parameters: %q", msgStr)
+				}
+				if processor.onMatch != nil {
+					processor.onMatch(match)
+				}
+				processor.dispatch(FormatIncident(match.Rule, match.Parameters, msgStr, "SYSLOGPROC", processor.clock.Now()))
 			}
 		}(processor)
 	}
@@ -191,29 +285,44 @@ func (processor *Processor) processEvents(rules []confighandler.Rule) {
 
 func (processor *Processor) publishIncidents() {
 
-	go func(incidentChannel chan lib.Incident, queueName string) {
-		var body []byte
-		var err error
+	go func(incidentChannel chan lib.Incident, rateLimitedChannel chan lib.Incident) {
 		for {
-			msg := <-incidentChannel
-
-			if body, err = msg.IncidentToJSON(); err != nil {
-				glog.Errorf("Error marshaling incident to JSON: %s", err)
-				continue
-			}
-
-			if err = processor.OutputEndpoint.Channel.Publish(
-				"",        // exchange
-				queueName, // routing key
-				false,     // mandatory
-				false,     // immediate
-				amqp.Publishing{
-					ContentType: "text/plain",
-					Body:        body,
-				}); err != nil {
-				glog.Errorf("Error publishing incident to the Executor's queue, %s", err)
+			select {
+			case msg := <-incidentChannel:
+				processor.publish(msg, processor.OutputEndpoint.AmqpQueue.Name)
+			case msg := <-rateLimitedChannel:
+				processor.publish(msg, processor.OutputEndpoint.RateLimitedQueue.Name)
 			}
 		}
-	}(processor.IncidentChannel, processor.OutputEndpoint.AmqpQueue.Name)
+	}(processor.IncidentChannel, processor.RateLimitedChannel)
+
+}
 
+// publish pushes an incident to the given queue, with its ID and creation time
+// as message ID and timestamp.
+func (processor *Processor) publish(msg lib.Incident, queueName string) {
+	if err := msg.TransitionAt(lib.StateQueued, processor.clock.Now()); err != nil {
+		glog.Warning(err)
+	}
+	body, err := msg.IncidentToJSON()
+	if err != nil {
+		glog.Errorf("Error marshaling incident to JSON: %s", err)
+		return
+	}
+
+	if err = processor.OutputEndpoint.Channel.Publish(
+		"",        // exchange
+		queueName, // routing key
+		false,     // mandatory
+		false,     // immediate
+		amqp.Publishing{
+			ContentType: "text/plain",
+			MessageId:   msg.ID,
+			Timestamp:   msg.CreatedAt,
+			Body:        body,
+		}); err != nil {
+		glog.Errorf("Error publishing incident %s to queue %s, %s", msg.ID, queueName, err)
+		return
+	}
+	glog.V(1).Infof("Published incident %s of rule %s to queue %s", msg.ID, msg.Rule.RuleName, queueName)
 }
@@ -0,0 +1,198 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// rateLimitRule is the name of the rule of the alert incidents diverted when a limit trips.
+const rateLimitRule = "goar_rate_limit"
+
+// rateLimiter caps the number of incidents published, per rule, per DeviceType and
+// globally. Incidents over any limit are diverted, to be reviewed by a human instead
+// of being executed, and an alert incident is diverted with them every time a limit
+// trips. Alerts have no commands, they never go to the executors.
+type rateLimiter struct {
+	mu     sync.Mutex
+	clock  clock
+	divert func(lib.Incident)
+
+	global      *tokenBucket
+	deviceTypes map[string]*tokenBucket
+	rules       map[string]*tokenBucket
+	limits      map[string]confighandler.RateLimit
+}
+
+// tokenBucket holds up to burst tokens, refilled at rate tokens per second.
+type tokenBucket struct {
+	tokens float64
+	burst  float64
+	rate   float64
+	last   time.Time
+	// tripped is set when an incident was denied and reset by the next allowed one.
+	tripped bool
+}
+
+func newRateLimiter(clock clock, divert func(lib.Incident)) *rateLimiter {
+	return &rateLimiter{
+		clock:       clock,
+		divert:      divert,
+		deviceTypes: make(map[string]*tokenBucket),
+		rules:       make(map[string]*tokenBucket),
+		limits:      make(map[string]confighandler.RateLimit),
+	}
+}
+
+// SetLimits configures the global and per DeviceType limits.
+func (r *rateLimiter) SetLimits(global confighandler.RateLimit, deviceTypes map[string]confighandler.RateLimit) {
+	now := r.clock.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.global = newTokenBucket(global, now)
+	r.deviceTypes = make(map[string]*tokenBucket, len(deviceTypes))
+	for deviceType, limit := range deviceTypes {
+		r.deviceTypes[deviceType] = newTokenBucket(limit, now)
+	}
+}
+
+// Filter implements incidentFilter.
+func (r *rateLimiter) Filter(incident lib.Incident, emit func(lib.Incident)) {
+	if incident.FollowUpOf != "" {
+		// only reports on an incident that went through the limits
+		emit(incident)
+		return
+	}
+	now := r.clock.Now()
+	rule := incident.Rule
+
+	r.mu.Lock()
+	buckets := []struct {
+		name   string
+		bucket *tokenBucket
+	}{
+		{"rule " + rule.RuleName, r.ruleBucket(rule, now)},
+		{"device type " + rule.DeviceType, r.deviceTypes[rule.DeviceType]},
+		{"global", r.global},
+	}
+
+	var alerts []lib.Incident
+	allowed := true
+	for _, limit := range buckets {
+		if limit.bucket == nil || limit.bucket.allow(now) {
+			continue
+		}
+		allowed = false
+		if !limit.bucket.tripped {
+			limit.bucket.tripped = true
+			alerts = append(alerts, rateLimitAlert(limit.name, incident, now))
+		}
+	}
+	if allowed {
+		for _, limit := range buckets {
+			if limit.bucket != nil {
+				limit.bucket.take()
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	for _, alert := range alerts {
+		glog.Warningf("Rate limit tripped: %s", alert.RawIncident)
+		r.divert(alert)
+	}
+	if !allowed {
+		glog.Warningf("Incident %s of rule %s for %v over rate limit, diverted for review", incident.ID, rule.RuleName, incident.Parameters)
+		r.divert(incident)
+		return
+	}
+	emit(incident)
+}
+
+// Flush implements incidentFilter, nothing is ever held.
+func (r *rateLimiter) Flush(emit func(lib.Incident)) {}
+
+// ruleBucket returns the bucket of a rule, created or replaced when the rule limit changes.
+// Must be called with mu held.
+func (r *rateLimiter) ruleBucket(rule confighandler.Rule, now time.Time) *tokenBucket {
+	if rule.RateLimit.Rate <= 0 {
+		return nil
+	}
+	if bucket, ok := r.rules[rule.RuleName]; ok && r.limits[rule.RuleName] == rule.RateLimit {
+		return bucket
+	}
+	bucket := newTokenBucket(rule.RateLimit, now)
+	r.rules[rule.RuleName] = bucket
+	r.limits[rule.RuleName] = rule.RateLimit
+	return bucket
+}
+
+// rateLimitAlert builds the alert incident published when the limit of the given name trips at now.
+func rateLimitAlert(limit string, incident lib.Incident, now time.Time) lib.Incident {
+	alert := lib.Incident{
+		ID:        lib.NewIncidentID(),
+		CreatedAt: now,
+		Rule: confighandler.Rule{
+			RuleName:  rateLimitRule,
+			AlertType: "Rate Limit",
+		},
+		RawIncident: fmt.Sprintf("%s rate limit exceeded by incident %s of rule %s, further incidents are diverted for review",
+			limit, incident.ID, incident.Rule.RuleName),
+		Engine: incident.Engine,
+		Parameters: map[string]string{
+			"limit": limit,
+			"rule":  incident.Rule.RuleName,
+		},
+	}
+	alert.TransitionAt(lib.StateDetected, now)
+	return alert
+}
+
+// newTokenBucket returns a full bucket for limit at now, nil if limit is disabled.
+func newTokenBucket(limit confighandler.RateLimit, now time.Time) *tokenBucket {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	per := limit.Per
+	if per <= 0 {
+		per = time.Second
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.Rate
+	}
+	return &tokenBucket{
+		tokens: float64(burst),
+		burst:  float64(burst),
+		rate:   float64(limit.Rate) / per.Seconds(),
+		last:   now,
+	}
+}
+
+// allow refills the bucket and reports whether a token is available, without taking it.
+func (bucket *tokenBucket) allow(now time.Time) bool {
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+	bucket.last = now
+	return bucket.tokens >= 1
+}
+
+// take consumes a token, allow must have returned true.
+func (bucket *tokenBucket) take() {
+	bucket.tokens--
+	bucket.tripped = false
+}
@@ -0,0 +1,168 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// Outcomes of an incident sent to the rate limiter.
+const (
+	passed   = "passed"
+	diverted = "diverted"
+)
+
+// rateLimitTest sends incidents to a rate limiter on a test clock.
+type rateLimitTest struct {
+	t       *testing.T
+	clock   *replayClock
+	limiter *rateLimiter
+	// alerts are the limits of the alerts diverted so far.
+	alerts []string
+	last   string
+}
+
+func newRateLimitTest(t *testing.T) *rateLimitTest {
+	test := &rateLimitTest{t: t, clock: newTestClock()}
+	test.limiter = newRateLimiter(test.clock, test.divert)
+	return test
+}
+
+func (test *rateLimitTest) divert(incident lib.Incident) {
+	if incident.Rule.RuleName != rateLimitRule {
+		test.last = diverted
+		return
+	}
+	now := test.clock.Now()
+	if len(incident.WorkflowSteps()) > 0 || incident.State != lib.StateDetected || !incident.CreatedAt.Equal(now) {
+		test.t.Errorf("alert %s has steps %v and state %s at %s, want no steps, detected at %s",
+			incident.RawIncident, incident.WorkflowSteps(), incident.State, incident.CreatedAt, now)
+	}
+	test.alerts = append(test.alerts, incident.Parameters["limit"]+" by "+incident.Parameters["rule"])
+}
+
+// send sends an incident of rule at after the start of the test, checking its outcome.
+func (test *rateLimitTest) send(after time.Duration, rule confighandler.Rule, outcome string) {
+	test.t.Helper()
+	at(test.clock, after)
+	test.last = ""
+	test.limiter.Filter(testIncident(rule, "", "hostname", "r1"), func(lib.Incident) {
+		test.last = passed
+	})
+	if test.last != outcome {
+		test.t.Errorf("incident of %s at %s %s, want %s", rule.RuleName, after, test.last, outcome)
+	}
+}
+
+// checkAlerts checks the alerts diverted since the last check.
+func (test *rateLimitTest) checkAlerts(want ...string) {
+	test.t.Helper()
+	if !reflect.DeepEqual(test.alerts, want) && len(test.alerts)+len(want) > 0 {
+		test.t.Errorf("alerts %v, want %v", test.alerts, want)
+	}
+	test.alerts = nil
+}
+
+func TestRateLimiterRule(t *testing.T) {
+	limited := confighandler.Rule{RuleName: "limited", RateLimit: confighandler.RateLimit{Rate: 2, Per: time.Minute}}
+	other := confighandler.Rule{RuleName: "other"}
+	test := newRateLimitTest(t)
+
+	test.send(0, limited, passed)
+	test.send(time.Second, limited, passed)
+	test.send(2*time.Second, limited, diverted)
+	test.checkAlerts("rule limited by limited")
+	// one alert per trip
+	test.send(3*time.Second, limited, diverted)
+	test.send(3*time.Second, other, passed)
+	test.checkAlerts()
+
+	// a token every 30s
+	test.send(31*time.Second, limited, passed)
+	test.send(32*time.Second, limited, diverted)
+	test.checkAlerts("rule limited by limited")
+
+	// a reloaded rule with another limit starts with a full bucket
+	raised := limited
+	raised.RateLimit.Rate = 3
+	test.send(33*time.Second, raised, passed)
+	test.send(33*time.Second, raised, passed)
+	test.send(33*time.Second, raised, passed)
+	test.send(33*time.Second, raised, diverted)
+	test.checkAlerts("rule limited by limited")
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	limited := confighandler.Rule{RuleName: "limited", RateLimit: confighandler.RateLimit{Rate: 1, Per: time.Minute, Burst: 3}}
+	test := newRateLimitTest(t)
+
+	for i := 0; i < 3; i++ {
+		test.send(0, limited, passed)
+	}
+	test.send(0, limited, diverted)
+	// refilled at the rate, up to the burst
+	test.send(time.Hour, limited, passed)
+	test.send(time.Hour, limited, passed)
+	test.send(time.Hour, limited, passed)
+	test.send(time.Hour, limited, diverted)
+	test.checkAlerts("rule limited by limited", "rule limited by limited")
+}
+
+func TestRateLimiterDeviceTypeAndGlobal(t *testing.T) {
+	arista := confighandler.Rule{RuleName: "arista_down", DeviceType: "arista"}
+	aristaCPU := confighandler.Rule{RuleName: "arista_cpu", DeviceType: "arista"}
+	junos := confighandler.Rule{RuleName: "junos_down", DeviceType: "junos"}
+	test := newRateLimitTest(t)
+	test.limiter.SetLimits(
+		confighandler.RateLimit{Rate: 4, Per: time.Hour},
+		map[string]confighandler.RateLimit{"arista": {Rate: 2, Per: time.Hour}},
+	)
+
+	// the rules of a device type share its bucket
+	test.send(0, arista, passed)
+	test.send(0, aristaCPU, passed)
+	test.send(0, arista, diverted)
+	test.checkAlerts("device type arista by arista_down")
+
+	// incidents diverted by a limit take no token from the others
+	test.send(0, junos, passed)
+	test.send(0, junos, passed)
+	test.send(0, junos, diverted)
+	test.send(0, aristaCPU, diverted)
+	test.checkAlerts("global by junos_down")
+
+	// without global limit, other device types are not limited
+	test.limiter.SetLimits(confighandler.RateLimit{}, map[string]confighandler.RateLimit{"arista": {Rate: 1, Per: time.Hour}})
+	for i := 0; i < 5; i++ {
+		test.send(time.Second, junos, passed)
+	}
+	test.send(time.Second, arista, passed)
+	test.send(time.Second, arista, diverted)
+	test.checkAlerts("device type arista by arista_down")
+}
+
+func TestRateLimiterFollowUps(t *testing.T) {
+	limited := confighandler.Rule{RuleName: "limited", RateLimit: confighandler.RateLimit{Rate: 1, Per: time.Hour}}
+	test := newRateLimitTest(t)
+
+	test.send(0, limited, passed)
+	followUp := testIncident(limited, "")
+	followUp.FollowUpOf = "first"
+	for i := 0; i < 3; i++ {
+		test.last = ""
+		test.limiter.Filter(followUp, func(lib.Incident) { test.last = passed })
+		if test.last != passed {
+			t.Fatalf("follow-up %d %s, want passed over the limit", i, test.last)
+		}
+	}
+	test.checkAlerts()
+}
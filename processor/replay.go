@@ -0,0 +1,164 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/matcher"
+)
+
+// maxReplayLineSize is the longest log line accepted when replaying.
+const maxReplayLineSize = 1024 * 1024
+
+// ReplaySummary counts what a replay went through.
+type ReplaySummary struct {
+	Lines int
+	// Incidents published, the follow-ups of the suppression windows are written but not counted
+	Incidents int
+	// Incidents collapsed into others by the rules Suppression
+	Suppressed uint64
+	// Incidents dropped because a clear line arrived during their HoldDown
+	AutoCleared uint64
+	// Incidents over a rate limit, which would have been diverted for review
+	RateLimited int
+	// Lines without a readable time, the time windows did not move for them
+	Untimed int
+	// Lines matched per rule, every rule in use is listed even without hits. Lines are
+	// counted when they match, whether their incident is published, suppressed, merged
+	// into the incident of a peer rule or auto-cleared.
+	Hits map[string]int
+}
+
+// Replay is a dry-run of the processor: log lines are read from in instead of the input
+// queue, go through the same rules and FormatIncident path, and every resulting incident
+// is written as a line of JSON to out instead of being published. With a processor from
+// NewReplayProcessor, the time windows of the filters are simulated from the time of the lines.
+func (processor *Processor) Replay(in io.Reader, out io.Writer) (*ReplaySummary, error) {
+	summary := &ReplaySummary{Hits: make(map[string]int)}
+	for _, rule := range processor.currentRules().Rules {
+		summary.Hits[rule.RuleName] = 0
+	}
+	var hitsMu sync.Mutex
+	processor.onMatch = func(match *matcher.Match) {
+		hitsMu.Lock()
+		summary.Hits[match.Rule.RuleName]++
+		hitsMu.Unlock()
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxReplayLineSize)
+		for scanner.Scan() {
+			summary.Lines++
+			// the scanner reuses its buffer, workers need their own copy
+			processor.RawLogChannel <- append([]byte(nil), scanner.Bytes()...)
+		}
+		close(processor.RawLogChannel)
+		readErr <- scanner.Err()
+	}()
+
+	go func() {
+		processor.processEvents()
+		if processor.replayClock != nil {
+			// let the time windows of the filters run out as if no more line arrived
+			processor.replayClock.Run()
+		}
+		// release whatever the filters still hold
+		processor.flushFilters()
+		close(processor.IncidentChannel)
+		close(processor.RateLimitedChannel)
+	}()
+
+	rateLimited := make(chan int)
+	go func() {
+		count := 0
+		for incident := range processor.RateLimitedChannel {
+			if incident.Rule.RuleName != rateLimitRule {
+				count++
+			}
+		}
+		rateLimited <- count
+	}()
+
+	var writeErr error
+	for incident := range processor.IncidentChannel {
+		if incident.FollowUpOf == "" {
+			summary.Incidents++
+		}
+
+		if writeErr != nil {
+			continue
+		}
+		var body []byte
+		if body, writeErr = incident.IncidentToJSON(); writeErr == nil {
+			_, writeErr = fmt.Fprintf(out, "%s\n", body)
+		}
+	}
+
+	summary.Suppressed = processor.suppressor.Suppressed()
+	summary.AutoCleared = processor.holdDown.AutoCleared()
+	summary.RateLimited = <-rateLimited
+	if processor.replayClock != nil {
+		summary.Untimed = processor.replayClock.Untimed()
+	} else {
+		summary.Untimed = summary.Lines
+	}
+	if err := <-readErr; err != nil {
+		return summary, err
+	}
+	return summary, writeErr
+}
+
+// Print writes a human readable summary, one line per rule sorted by hits.
+func (summary *ReplaySummary) Print(out io.Writer) {
+	names := make([]string, 0, len(summary.Hits))
+	for name := range summary.Hits {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if summary.Hits[names[i]] != summary.Hits[names[j]] {
+			return summary.Hits[names[i]] > summary.Hits[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	fmt.Fprintf(out, "Replayed %d line(s), %d incident(s), %d suppressed, %d auto-cleared, %d rate limited\n",
+		summary.Lines, summary.Incidents, summary.Suppressed, summary.AutoCleared, summary.RateLimited)
+	switch {
+	case summary.Lines > 0 && summary.Untimed == summary.Lines:
+		fmt.Fprintln(out, "No line has a readable time (BASEREG time capture), time windows were not simulated: counts are not what would have fired")
+	case summary.Untimed > 0:
+		fmt.Fprintf(out, "%d line(s) without a readable time (BASEREG time capture), time windows did not move for them\n", summary.Untimed)
+	}
+	for _, name := range names {
+		fmt.Fprintf(out, "%8d %s\n", summary.Hits[name], name)
+	}
+}
+
+// advanceClock moves the clock of a replay processor to the time of line, read from the
+// time capture of its header.
+func (processor *Processor) advanceClock(line string) {
+	if processor.replayClock == nil {
+		return
+	}
+	params, _ := processor.header.Parse(line)
+	at, err := parseLogTime(params[timeParameter], processor.replayClock.Now())
+	if err != nil {
+		glog.V(1).Infof("Replayed line without time, the clock does not move: %s", err)
+		processor.replayClock.skip()
+		return
+	}
+	processor.replayClock.Advance(at)
+}
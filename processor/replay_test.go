@@ -0,0 +1,118 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+	"github.com/facebookexperimental/GOAR/matcher"
+)
+
+func TestReplay(t *testing.T) {
+	processor := NewReplayProcessor()
+	if err := processor.SetBaseRegex(`^(?<time>\S+) (?<hostname>\S+) `); err != nil {
+		t.Fatalf("SetBaseRegex: %s", err)
+	}
+	ruleSet, err := matcher.NewRuleSet([]confighandler.Rule{
+		{RuleName: "link_down_arista", Regex: `Interface (?<interface>\S+), changed state to down`},
+		{RuleName: "link_down_junos", Regex: `SNMP_TRAP_LINK_DOWN: ifName (?<interface>\S+)`},
+		{
+			RuleName:    "cpu",
+			Regex:       `CPU utilization (?<utilization>\d+)`,
+			Suppression: confighandler.Suppression{Window: time.Minute, Keys: []string{"hostname"}},
+		},
+		{RuleName: "bgp_down", Regex: `BGP peer (?<peer>\S+) down`},
+	})
+	if err != nil {
+		t.Fatalf("NewRuleSet: %s", err)
+	}
+	processor.ruleSet.Store(ruleSet)
+	processor.correlator.SetTopology(&confighandler.Topology{
+		Window: 10 * time.Second,
+		Links: []confighandler.Link{
+			{A: confighandler.LinkEnd{Device: "r1", Interface: "et1"}, B: confighandler.LinkEnd{Device: "r2", Interface: "xe-0/0/1"}},
+		},
+	})
+
+	in := strings.Join([]string{
+		"2020-03-01T12:00:00Z r1 Ebra: Interface et1, changed state to down",
+		"2020-03-01T12:00:04Z r2 mib2d: SNMP_TRAP_LINK_DOWN: ifName xe-0/0/1",
+		"2020-03-01T12:01:00Z r3 CPU utilization 95",
+		"2020-03-01T12:01:10Z r3 CPU utilization 97",
+		"2020-03-01T12:01:20Z r3 CPU utilization 99",
+		"2020-03-01T12:02:00Z r3 all good",
+	}, "\n")
+	var out bytes.Buffer
+	summary, err := processor.Replay(strings.NewReader(in), &out)
+	if err != nil {
+		t.Fatalf("Replay: %s", err)
+	}
+
+	wantHits := map[string]int{"link_down_arista": 1, "link_down_junos": 1, "cpu": 3, "bgp_down": 0}
+	if !reflect.DeepEqual(summary.Hits, wantHits) {
+		t.Errorf("Hits = %v, want %v", summary.Hits, wantHits)
+	}
+	if summary.Lines != 6 || summary.Incidents != 2 || summary.Suppressed != 2 || summary.Untimed != 0 {
+		t.Errorf("summary = %+v, want 6 lines, 2 incidents, 2 suppressed, none untimed", summary)
+	}
+
+	// incidents carry the time of their line, not the time of the replay
+	wantCreated := map[string]time.Time{
+		"link_down_arista": testStart,
+		"cpu":              testStart.Add(time.Minute),
+	}
+	ids := make(map[string]string)
+	var followUps []lib.Incident
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var incident lib.Incident
+		if err := json.Unmarshal(scanner.Bytes(), &incident); err != nil {
+			t.Fatalf("incident %s: %s", scanner.Text(), err)
+		}
+		if incident.FollowUpOf != "" {
+			followUps = append(followUps, incident)
+			continue
+		}
+		ids[incident.Rule.RuleName] = incident.ID
+		want, ok := wantCreated[incident.Rule.RuleName]
+		if !ok {
+			t.Errorf("unexpected incident of rule %s", incident.Rule.RuleName)
+			continue
+		}
+		delete(wantCreated, incident.Rule.RuleName)
+		if !incident.CreatedAt.Equal(want) {
+			t.Errorf("incident of rule %s created at %s, want %s", incident.Rule.RuleName, incident.CreatedAt, want)
+		}
+		if len(incident.Transitions) == 0 || !incident.Transitions[0].At.Equal(want) {
+			t.Errorf("incident of rule %s transitions %v, want detected at %s", incident.Rule.RuleName, incident.Transitions, want)
+		}
+		if incident.Rule.RuleName == "link_down_arista" && (incident.Peer == nil || !incident.Peer.CreatedAt.Equal(testStart.Add(4*time.Second))) {
+			t.Errorf("merged incident peer = %+v, want the junos incident created 4s after the start", incident.Peer)
+		}
+	}
+	if len(wantCreated) > 0 {
+		t.Errorf("missing incidents of %v", wantCreated)
+	}
+
+	// the cpu window ends a minute after its first line
+	if len(followUps) != 1 {
+		t.Fatalf("follow-ups = %+v, want the one of the cpu incident", followUps)
+	}
+	if followUp := followUps[0]; followUp.FollowUpOf != ids["cpu"] || followUp.SuppressedCount != 2 ||
+		!followUp.CreatedAt.Equal(testStart.Add(2*time.Minute)) {
+		t.Errorf("follow-up of %s with %d suppressed created at %s, want the cpu incident %s with 2 suppressed 2m after the start",
+			followUp.FollowUpOf, followUp.SuppressedCount, followUp.CreatedAt, ids["cpu"])
+	}
+}
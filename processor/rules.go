@@ -0,0 +1,134 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/matcher"
+)
+
+// LoadRules reads, validates and compiles the rules file, resolving the workflows they
+// reference from workflowsPath (optional), and if everything is fine atomically
+// replaces the rules used by all event processors.
+// On error the rules currently in use are kept.
+func (processor *Processor) LoadRules(path string, workflowsPath string) error {
+	rules, err := confighandler.GetRules(path)
+	if err != nil {
+		return err
+	}
+
+	workflows := make(map[string]confighandler.Workflow)
+	if workflowsPath != "" {
+		if workflows, err = confighandler.GetWorkflows(workflowsPath); err != nil {
+			return err
+		}
+	}
+	if err := confighandler.ResolveWorkflows(rules, workflows); err != nil {
+		return err
+	}
+
+	ruleSet, err := matcher.NewRuleSet(rules)
+	if err != nil {
+		return err
+	}
+
+	if glog.V(2) {
+		glog.Infof("Read rules defined on %s: %v", path, spew.Sdump(rules))
+	}
+
+	if current := processor.currentRules(); current != nil {
+		added, removed, changed := diffRules(current.Rules, ruleSet.Rules)
+		glog.Infof("Reloaded rules from %s: added %v, removed %v, changed %v", path, added, removed, changed)
+	}
+	processor.ruleSet.Store(ruleSet)
+	return nil
+}
+
+// WatchRules reloads the rules and workflows files whenever the process receives SIGHUP
+// or the modification time of one of the files changes (checked every interval).
+func (processor *Processor) WatchRules(path string, workflowsPath string, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		lastMod := modTimes(path, workflowsPath)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hup:
+				glog.Infof("SIGHUP received, reloading rules from %s", path)
+			case <-ticker.C:
+				mod := modTimes(path, workflowsPath)
+				if mod == lastMod {
+					continue
+				}
+				glog.Infof("Rules file %s or workflows file %s changed, reloading", path, workflowsPath)
+			}
+			lastMod = modTimes(path, workflowsPath)
+
+			if err := processor.LoadRules(path, workflowsPath); err != nil {
+				glog.Errorf("Error reloading rules from %s, keeping current rules: %s", path, err)
+			}
+		}
+	}()
+}
+
+// currentRules returns the RuleSet in use, nil if rules were never loaded.
+func (processor *Processor) currentRules() *matcher.RuleSet {
+	ruleSet, _ := processor.ruleSet.Load().(*matcher.RuleSet)
+	return ruleSet
+}
+
+// diffRules compares two lists of rules by RuleName and returns the names of the rules
+// that were added, removed or changed in next compared to prev.
+func diffRules(prev, next []confighandler.Rule) (added, removed, changed []string) {
+	prevByName := make(map[string]confighandler.Rule, len(prev))
+	for _, rule := range prev {
+		prevByName[rule.RuleName] = rule
+	}
+
+	nextNames := make(map[string]bool, len(next))
+	for _, rule := range next {
+		nextNames[rule.RuleName] = true
+		old, ok := prevByName[rule.RuleName]
+		if !ok {
+			added = append(added, rule.RuleName)
+		} else if !reflect.DeepEqual(old, rule) {
+			changed = append(changed, rule.RuleName)
+		}
+	}
+
+	for _, rule := range prev {
+		if !nextNames[rule.RuleName] {
+			removed = append(removed, rule.RuleName)
+		}
+	}
+	return added, removed, changed
+}
+
+// modTimes returns the modification times of files, in nanoseconds,
+// zero for a file that cannot be read.
+func modTimes(rulesPath string, workflowsPath string) [2]int64 {
+	var times [2]int64
+	for i, path := range []string{rulesPath, workflowsPath} {
+		if info, err := os.Stat(path); err == nil {
+			times[i] = info.ModTime().UnixNano()
+		}
+	}
+	return times
+}
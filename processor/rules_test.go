@@ -0,0 +1,192 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+)
+
+func TestDiffRules(t *testing.T) {
+	down := confighandler.Rule{RuleName: "down", Regex: "changed state to down"}
+	cpu := confighandler.Rule{RuleName: "cpu", Regex: "CPU utilization"}
+	bgp := confighandler.Rule{RuleName: "bgp", Regex: "BGP peer down"}
+	downHeld := down
+	downHeld.HoldDown = 30 * time.Second
+
+	tests := []struct {
+		name                    string
+		prev, next              []confighandler.Rule
+		added, removed, changed []string
+	}{
+		{
+			name: "same rules",
+			prev: []confighandler.Rule{down, cpu},
+			next: []confighandler.Rule{down, cpu},
+		},
+		{
+			name: "reordered rules",
+			prev: []confighandler.Rule{down, cpu},
+			next: []confighandler.Rule{cpu, down},
+		},
+		{
+			name:  "rule added",
+			prev:  []confighandler.Rule{down},
+			next:  []confighandler.Rule{down, bgp},
+			added: []string{"bgp"},
+		},
+		{
+			name:    "rule removed",
+			prev:    []confighandler.Rule{down, cpu},
+			next:    []confighandler.Rule{down},
+			removed: []string{"cpu"},
+		},
+		{
+			name:    "rule changed",
+			prev:    []confighandler.Rule{down, cpu},
+			next:    []confighandler.Rule{downHeld, cpu},
+			changed: []string{"down"},
+		},
+		{
+			name:    "added, removed and changed",
+			prev:    []confighandler.Rule{down, cpu},
+			next:    []confighandler.Rule{bgp, downHeld},
+			added:   []string{"bgp"},
+			removed: []string{"cpu"},
+			changed: []string{"down"},
+		},
+		{
+			name:  "first load",
+			next:  []confighandler.Rule{down, cpu},
+			added: []string{"down", "cpu"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			added, removed, changed := diffRules(test.prev, test.next)
+			if !reflect.DeepEqual(added, test.added) {
+				t.Errorf("added = %v, want %v", added, test.added)
+			}
+			if !reflect.DeepEqual(removed, test.removed) {
+				t.Errorf("removed = %v, want %v", removed, test.removed)
+			}
+			if !reflect.DeepEqual(changed, test.changed) {
+				t.Errorf("changed = %v, want %v", changed, test.changed)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	rulesPath := filepath.Join(dir, "rules.yaml")
+	workflowsPath := filepath.Join(dir, "workflows.yaml")
+	if err := ioutil.WriteFile(workflowsPath, []byte(`
+- Name: bounce
+  Steps:
+    - {Name: bounce, Phase: remediation, Cmd: bounce.py}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each load is applied in order on the same processor, a failing one keeping
+	// the rules of the previous one.
+	loads := []struct {
+		name  string
+		rules string
+		fails bool
+		want  []string
+		// steps is the number of workflow steps resolved for the first rule in use.
+		steps int
+	}{
+		{
+			name: "initial rules",
+			rules: `
+- {RuleName: down, Regex: 'changed state to down'}
+- {RuleName: cpu, Regex: 'CPU utilization'}
+`,
+			want: []string{"down", "cpu"},
+		},
+		{
+			name: "rule added, removed and changed",
+			rules: `
+- {RuleName: down, Regex: 'changed state to down', Workflow: bounce}
+- {RuleName: bgp, Regex: 'BGP peer (?<peer>\S+) down'}
+`,
+			want:  []string{"down", "bgp"},
+			steps: 1,
+		},
+		{
+			name:  "broken yaml",
+			rules: "- {RuleName: down, Regex: 'down'",
+			fails: true,
+			want:  []string{"down", "bgp"},
+			steps: 1,
+		},
+		{
+			name: "bad regex",
+			rules: `
+- {RuleName: down, Regex: 'changed state to (down'}
+`,
+			fails: true,
+			want:  []string{"down", "bgp"},
+			steps: 1,
+		},
+		{
+			name: "duplicated rule",
+			rules: `
+- {RuleName: down, Regex: 'changed state to down'}
+- {RuleName: down, Regex: 'CPU utilization'}
+`,
+			fails: true,
+			want:  []string{"down", "bgp"},
+			steps: 1,
+		},
+		{
+			name: "unknown workflow",
+			rules: `
+- {RuleName: down, Regex: 'changed state to down', Workflow: drain}
+`,
+			fails: true,
+			want:  []string{"down", "bgp"},
+			steps: 1,
+		},
+		{
+			name: "fixed rules",
+			rules: `
+- {RuleName: cpu, Regex: 'CPU utilization'}
+`,
+			want: []string{"cpu"},
+		},
+	}
+
+	processor := NewProcessor()
+	for _, load := range loads {
+		if err := ioutil.WriteFile(rulesPath, []byte(load.rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := processor.LoadRules(rulesPath, workflowsPath)
+		if load.fails != (err != nil) {
+			t.Fatalf("%s: LoadRules error = %v, want failure %t", load.name, err, load.fails)
+		}
+		var names []string
+		for _, rule := range processor.currentRules().Rules {
+			names = append(names, rule.RuleName)
+		}
+		if !reflect.DeepEqual(names, load.want) {
+			t.Fatalf("%s: rules in use = %v, want %v", load.name, names, load.want)
+		}
+		if steps := processor.currentRules().Rules[0].Steps; len(steps) != load.steps {
+			t.Fatalf("%s: rule %s steps = %v, want %d", load.name, names[0], steps, load.steps)
+		}
+	}
+}
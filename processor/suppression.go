@@ -0,0 +1,138 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// suppressor collapses repeated incidents. The first incident of a rule with a
+// Suppression window is published right away and opens the window, identical incidents
+// (same rule, same key parameters) arriving meanwhile are dropped and counted. When the
+// window ends with incidents dropped, a follow-up of the first incident carrying the
+// count is published (see lib.Incident.FollowUpOf).
+type suppressor struct {
+	mu      sync.Mutex
+	clock   clock
+	windows map[string]*suppressionWindow
+
+	suppressed uint64
+}
+
+// suppressionWindow follows the incidents identical to a published one.
+type suppressionWindow struct {
+	incident lib.Incident
+	until    time.Time
+	timer    clockTimer
+	// suppressed counts the incidents dropped until the end of the window.
+	suppressed int
+	// emit publishes the follow-up of incident.
+	emit func(lib.Incident)
+}
+
+func newSuppressor(clock clock) *suppressor {
+	return &suppressor{clock: clock, windows: make(map[string]*suppressionWindow)}
+}
+
+// Filter implements incidentFilter.
+func (s *suppressor) Filter(incident lib.Incident, emit func(lib.Incident)) {
+	suppression := incident.Rule.Suppression
+	if suppression.Window <= 0 {
+		emit(incident)
+		return
+	}
+	key := incidentKey(incident, suppression.Keys)
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	if window, ok := s.windows[key]; ok && now.Before(window.until) {
+		window.suppressed++
+		suppressed := window.suppressed
+		s.mu.Unlock()
+		atomic.AddUint64(&s.suppressed, 1)
+		suppress(&incident, now, 1, fmt.Sprintf("identical to incident %s, %d so far", window.incident.ID, suppressed))
+		return
+	}
+	// a previous window whose timer did not fire yet still reports its own count
+	window := &suppressionWindow{incident: incident, until: now.Add(suppression.Window), emit: emit}
+	window.timer = s.clock.AfterFunc(suppression.Window, func() {
+		s.end(key, window)
+	})
+	s.windows[key] = window
+	s.mu.Unlock()
+
+	emit(incident)
+}
+
+// end is called when the window of key is over, publishing the follow-up of its
+// incident if identical ones were suppressed meanwhile.
+func (s *suppressor) end(key string, window *suppressionWindow) {
+	s.mu.Lock()
+	if s.windows[key] == window {
+		delete(s.windows, key)
+	}
+	suppressed := window.suppressed
+	window.suppressed = 0
+	s.mu.Unlock()
+
+	if suppressed > 0 {
+		s.followUp(window.incident, suppressed, window.emit)
+	}
+}
+
+// followUp publishes with emit the follow-up of incident, suppressed identical
+// incidents having been dropped during its window.
+func (s *suppressor) followUp(incident lib.Incident, suppressed int, emit func(lib.Incident)) {
+	glog.Infof("Suppressed %d incident(s) of rule %s for %v into incident %s", suppressed, incident.Rule.RuleName, incident.Parameters, incident.ID)
+
+	now := s.clock.Now()
+	followUp := lib.Incident{
+		ID:              lib.NewIncidentID(),
+		CreatedAt:       now,
+		Rule:            incident.Rule,
+		RawIncident:     fmt.Sprintf("%d incident(s) identical to incident %s suppressed", suppressed, incident.ID),
+		Engine:          incident.Engine,
+		Parameters:      incident.Parameters,
+		SuppressedCount: suppressed,
+		FollowUpOf:      incident.ID,
+	}
+	followUp.TransitionAt(lib.StateDetected, now)
+	emit(followUp)
+}
+
+// Suppressed returns the number of incidents suppressed so far.
+func (s *suppressor) Suppressed() uint64 {
+	return atomic.LoadUint64(&s.suppressed)
+}
+
+// Flush implements incidentFilter, publishing the follow-ups of the windows still open.
+func (s *suppressor) Flush(emit func(lib.Incident)) {
+	var ended []*suppressionWindow
+	var counts []int
+	s.mu.Lock()
+	for key, window := range s.windows {
+		window.timer.Stop()
+		delete(s.windows, key)
+		if window.suppressed > 0 {
+			ended = append(ended, window)
+			counts = append(counts, window.suppressed)
+			window.suppressed = 0
+		}
+	}
+	s.mu.Unlock()
+
+	for i, window := range ended {
+		s.followUp(window.incident, counts[i], emit)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+func TestSuppressor(t *testing.T) {
+	byHostname := confighandler.Suppression{Window: time.Minute, Keys: []string{"hostname"}}
+	tests := []struct {
+		name        string
+		suppression confighandler.Suppression
+		// lines are "<after> <hostname> <raw>", fed in order.
+		lines []string
+		// published are "<after> <raw>" for the incidents emitted, and
+		// "<after> follow-up of <raw>: <count>" for the follow-ups.
+		published  []string
+		suppressed uint64
+	}{
+		{
+			name:        "first incident right away, follow-up at the end of the window",
+			suppression: byHostname,
+			lines:       []string{"0s r1 a", "10s r1 b", "59s r1 c"},
+			published:   []string{"0s a", "1m0s follow-up of a: 2"},
+			suppressed:  2,
+		},
+		{
+			name:        "no follow-up without suppressed incidents",
+			suppression: byHostname,
+			lines:       []string{"0s r1 a", "1m0s r1 b", "2m30s r1 c"},
+			published:   []string{"0s a", "1m0s b", "2m30s c"},
+		},
+		{
+			name:        "later incident opens its own window",
+			suppression: byHostname,
+			lines:       []string{"0s r1 a", "10s r1 b", "20s r1 c", "1m30s r1 d", "1m40s r1 e"},
+			published:   []string{"0s a", "1m0s follow-up of a: 2", "1m30s d", "2m30s follow-up of d: 1"},
+			suppressed:  3,
+		},
+		{
+			name:        "windows per key",
+			suppression: byHostname,
+			lines:       []string{"0s r1 a", "1s r2 b", "2s r1 c", "3s r2 d", "4s r2 e"},
+			published:   []string{"0s a", "1s b", "1m0s follow-up of a: 1", "1m1s follow-up of b: 2"},
+			suppressed:  3,
+		},
+		{
+			name:        "without keys every incident of the rule is identical",
+			suppression: confighandler.Suppression{Window: time.Minute},
+			lines:       []string{"0s r1 a", "1s r2 b"},
+			published:   []string{"0s a", "1m0s follow-up of a: 1"},
+			suppressed:  1,
+		},
+		{
+			name:      "rule without window",
+			lines:     []string{"0s r1 a", "1s r1 b"},
+			published: []string{"0s a", "1s b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newTestClock()
+			suppressor := newSuppressor(clock)
+			output := &emitted{clock: clock}
+			rule := confighandler.Rule{RuleName: "cpu", Suppression: test.suppression}
+			for _, line := range test.lines {
+				fields := strings.Fields(line)
+				after, err := time.ParseDuration(fields[0])
+				if err != nil {
+					t.Fatalf("line %q: %s", line, err)
+				}
+				at(clock, after)
+				suppressor.Filter(testIncident(rule, fields[2], "hostname", fields[1]), output.emit)
+			}
+			clock.Run()
+
+			if published := describeSuppressed(t, output); !reflect.DeepEqual(published, test.published) {
+				t.Errorf("published\n%s\nwant\n%s", strings.Join(published, "\n"), strings.Join(test.published, "\n"))
+			}
+			if suppressed := suppressor.Suppressed(); suppressed != test.suppressed {
+				t.Errorf("Suppressed() = %d, want %d", suppressed, test.suppressed)
+			}
+			if len(suppressor.windows) != 0 {
+				t.Errorf("%d window(s) left once the time went on", len(suppressor.windows))
+			}
+		})
+	}
+}
+
+func TestSuppressorFlush(t *testing.T) {
+	clock := newTestClock()
+	suppressor := newSuppressor(clock)
+	output := &emitted{clock: clock}
+	rule := confighandler.Rule{RuleName: "cpu", Suppression: confighandler.Suppression{Window: time.Minute}}
+
+	suppressor.Filter(testIncident(rule, "a"), output.emit)
+	at(clock, 10*time.Second)
+	suppressor.Filter(testIncident(rule, "b"), output.emit)
+	at(clock, 20*time.Second)
+	suppressor.Flush(output.emit)
+	// the window timer was stopped by the flush
+	clock.Run()
+
+	want := []string{"0s a", "20s follow-up of a: 1"}
+	if published := describeSuppressed(t, output); !reflect.DeepEqual(published, want) {
+		t.Errorf("published %v, want %v", published, want)
+	}
+}
+
+// describeSuppressed describes the incidents emitted by a suppressor, checking the
+// follow-ups refer to an incident emitted before them.
+func describeSuppressed(t *testing.T, output *emitted) []string {
+	t.Helper()
+	originals := make(map[string]lib.Incident)
+	var published []string
+	for i, incident := range output.incidents {
+		if incident.FollowUpOf == "" {
+			originals[incident.ID] = incident
+			published = append(published, fmt.Sprintf("%s %s", output.after[i], incident.RawIncident))
+			continue
+		}
+		original, ok := originals[incident.FollowUpOf]
+		if !ok {
+			t.Errorf("follow-up %s of unknown incident %s", incident.ID, incident.FollowUpOf)
+			continue
+		}
+		if !reflect.DeepEqual(incident.Parameters, original.Parameters) || incident.State != lib.StateDetected {
+			t.Errorf("follow-up of %s has parameters %v and state %s, want %v and %s",
+				original.RawIncident, incident.Parameters, incident.State, original.Parameters, lib.StateDetected)
+		}
+		published = append(published, fmt.Sprintf("%s follow-up of %s: %d", output.after[i], original.RawIncident, incident.SuppressedCount))
+	}
+	return published
+}
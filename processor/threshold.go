@@ -0,0 +1,117 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// thresholdSweepInterval defines how often groups that stopped matching are forgotten.
+const thresholdSweepInterval = time.Minute
+
+// thresholdCounter keeps a sliding window of matches per rule and GroupBy key for
+// rules with a Threshold. An incident is only emitted once Count matches happened
+// within Window, it then carries every raw line that contributed.
+type thresholdCounter struct {
+	mu        sync.Mutex
+	clock     clock
+	groups    map[string]*thresholdGroup
+	lastSweep time.Time
+}
+
+// thresholdGroup holds the recent matches of a rule for a GroupBy key.
+type thresholdGroup struct {
+	hits []thresholdHit
+	// window of the rule when the group last matched.
+	window time.Duration
+}
+
+// thresholdHit is a single match counted towards a threshold.
+type thresholdHit struct {
+	at   time.Time
+	line string
+}
+
+func newThresholdCounter(clock clock) *thresholdCounter {
+	return &thresholdCounter{
+		clock:     clock,
+		groups:    make(map[string]*thresholdGroup),
+		lastSweep: clock.Now(),
+	}
+}
+
+// Filter implements incidentFilter.
+func (t *thresholdCounter) Filter(incident lib.Incident, emit func(lib.Incident)) {
+	threshold := incident.Rule.Threshold
+	if threshold.Count <= 1 {
+		emit(incident)
+		return
+	}
+	key := incidentKey(incident, threshold.GroupBy)
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	var hits []thresholdHit
+	if group, ok := t.groups[key]; ok {
+		hits = recentHits(group.hits, now, threshold.Window)
+	}
+	hits = append(hits, thresholdHit{at: now, line: incident.RawIncident})
+	if len(hits) < threshold.Count {
+		t.groups[key] = &thresholdGroup{hits: hits, window: threshold.Window}
+		t.sweep(now)
+		t.mu.Unlock()
+		glog.V(1).Infof("Rule %s matched %d/%d times for %v", incident.Rule.RuleName, len(hits), threshold.Count, threshold.GroupBy)
+		return
+	}
+	// threshold reached, start counting again from scratch
+	delete(t.groups, key)
+	t.mu.Unlock()
+
+	incident.RawIncidents = make([]string, 0, len(hits))
+	for _, hit := range hits {
+		incident.RawIncidents = append(incident.RawIncidents, hit.line)
+	}
+	emit(incident)
+}
+
+// Flush implements incidentFilter. Groups below their threshold never become incidents.
+func (t *thresholdCounter) Flush(emit func(lib.Incident)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.groups = make(map[string]*thresholdGroup)
+}
+
+// sweep forgets groups without any hit in the last window of their rule, a zero
+// window never expiring hits. Must be called with mu held.
+func (t *thresholdCounter) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < thresholdSweepInterval {
+		return
+	}
+	t.lastSweep = now
+	for key, group := range t.groups {
+		if group.window > 0 && now.Sub(group.hits[len(group.hits)-1].at) > group.window {
+			delete(t.groups, key)
+		}
+	}
+}
+
+// recentHits drops the hits older than window. A zero window never expires hits.
+func recentHits(hits []thresholdHit, now time.Time, window time.Duration) []thresholdHit {
+	if window <= 0 {
+		return hits
+	}
+	first := 0
+	for first < len(hits) && now.Sub(hits[first].at) > window {
+		first++
+	}
+	return hits[first:]
+}
@@ -0,0 +1,155 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+// All rights reserved.
+
+// This source code is licensed under the BSD-style license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/facebookexperimental/GOAR/confighandler"
+	"github.com/facebookexperimental/GOAR/lib"
+)
+
+// thresholdTest feeds matches to a threshold counter on a test clock.
+type thresholdTest struct {
+	t       *testing.T
+	clock   *replayClock
+	counter *thresholdCounter
+}
+
+func newThresholdTest(t *testing.T) *thresholdTest {
+	clock := newTestClock()
+	return &thresholdTest{t: t, clock: clock, counter: newThresholdCounter(clock)}
+}
+
+// match feeds a match of rule for hostname at after the start of the test, and checks
+// the RawIncidents of the incident it completes, nil for none.
+func (test *thresholdTest) match(after time.Duration, rule confighandler.Rule, hostname string, raw string, fired []string) {
+	test.t.Helper()
+	at(test.clock, after)
+	var emitted []lib.Incident
+	test.counter.Filter(testIncident(rule, raw, "hostname", hostname), func(incident lib.Incident) {
+		emitted = append(emitted, incident)
+	})
+
+	switch {
+	case fired == nil && len(emitted) > 0:
+		test.t.Errorf("%s %s at %s fired %v, want no incident", rule.RuleName, raw, after, emitted[0].RawIncidents)
+	case fired != nil && len(emitted) != 1:
+		test.t.Errorf("%s %s at %s fired %d incident(s), want one with %v", rule.RuleName, raw, after, len(emitted), fired)
+	case fired != nil && !reflect.DeepEqual(emitted[0].RawIncidents, fired):
+		test.t.Errorf("%s %s at %s fired %v, want %v", rule.RuleName, raw, after, emitted[0].RawIncidents, fired)
+	case fired != nil && emitted[0].RawIncident != raw:
+		test.t.Errorf("%s %s at %s fired incident of line %s, want the completing line", rule.RuleName, raw, after, emitted[0].RawIncident)
+	}
+}
+
+// tracked reports whether the counter still has matches of rule for hostname.
+func (test *thresholdTest) tracked(rule confighandler.Rule, hostname string) bool {
+	_, ok := test.counter.groups[incidentKey(testIncident(rule, "", "hostname", hostname), rule.Threshold.GroupBy)]
+	return ok
+}
+
+func TestThresholdSlidingWindow(t *testing.T) {
+	rule := confighandler.Rule{RuleName: "crc", Threshold: confighandler.Threshold{Count: 3, Window: time.Minute}}
+	test := newThresholdTest(t)
+
+	test.match(0, rule, "r1", "a", nil)
+	test.match(50*time.Second, rule, "r1", "b", nil)
+	// a is more than a minute old, b and c are not enough
+	test.match(61*time.Second, rule, "r1", "c", nil)
+	test.match(70*time.Second, rule, "r1", "d", []string{"b", "c", "d"})
+	// counting starts again from zero
+	test.match(71*time.Second, rule, "r1", "e", nil)
+	test.match(72*time.Second, rule, "r1", "f", nil)
+	test.match(73*time.Second, rule, "r1", "g", []string{"e", "f", "g"})
+}
+
+func TestThresholdGroupBy(t *testing.T) {
+	rule := confighandler.Rule{RuleName: "crc", Threshold: confighandler.Threshold{Count: 2, Window: time.Minute, GroupBy: []string{"hostname"}}}
+	ungrouped := confighandler.Rule{RuleName: "bgp", Threshold: confighandler.Threshold{Count: 2, Window: time.Minute}}
+	test := newThresholdTest(t)
+
+	test.match(0, rule, "r1", "a", nil)
+	test.match(time.Second, rule, "r2", "b", nil)
+	test.match(2*time.Second, ungrouped, "r1", "c", nil)
+	test.match(3*time.Second, rule, "r2", "d", []string{"b", "d"})
+	test.match(4*time.Second, ungrouped, "r2", "e", []string{"c", "e"})
+	test.match(5*time.Second, rule, "r1", "f", []string{"a", "f"})
+}
+
+func TestThresholdWithoutCount(t *testing.T) {
+	test := newThresholdTest(t)
+	for _, threshold := range []confighandler.Threshold{{}, {Count: 1, Window: time.Minute}} {
+		rule := confighandler.Rule{RuleName: "down", Threshold: threshold}
+		var emitted []lib.Incident
+		test.counter.Filter(testIncident(rule, "a", "hostname", "r1"), func(incident lib.Incident) {
+			emitted = append(emitted, incident)
+		})
+		if len(emitted) != 1 || emitted[0].RawIncidents != nil {
+			t.Errorf("threshold %+v emitted %+v, want the incident as is", threshold, emitted)
+		}
+	}
+}
+
+func TestThresholdZeroWindow(t *testing.T) {
+	rule := confighandler.Rule{RuleName: "crc", Threshold: confighandler.Threshold{Count: 2}}
+	test := newThresholdTest(t)
+
+	test.match(0, rule, "r1", "a", nil)
+	test.match(24*time.Hour, rule, "r2", "b", []string{"a", "b"})
+}
+
+func TestThresholdSweepPerRuleWindow(t *testing.T) {
+	short := confighandler.Rule{RuleName: "short", Threshold: confighandler.Threshold{Count: 2, Window: 30 * time.Second, GroupBy: []string{"hostname"}}}
+	long := confighandler.Rule{RuleName: "long", Threshold: confighandler.Threshold{Count: 2, Window: time.Hour, GroupBy: []string{"hostname"}}}
+	forever := confighandler.Rule{RuleName: "forever", Threshold: confighandler.Threshold{Count: 2, GroupBy: []string{"hostname"}}}
+	test := newThresholdTest(t)
+
+	test.match(0, short, "r1", "a", nil)
+	test.match(0, long, "r1", "b", nil)
+	test.match(0, forever, "r1", "c", nil)
+
+	// no sweep before the interval, even for expired groups
+	test.match(thresholdSweepInterval-time.Second, short, "r2", "d", nil)
+	if !test.tracked(short, "r1") {
+		t.Errorf("group of short swept before the sweep interval")
+	}
+
+	// each group is swept with the window of its own rule
+	test.match(2*thresholdSweepInterval, long, "r3", "e", nil)
+	for _, group := range []struct {
+		rule     confighandler.Rule
+		hostname string
+		tracked  bool
+	}{
+		{short, "r1", false},
+		{short, "r2", false},
+		{long, "r1", true},
+		{forever, "r1", true},
+		{long, "r3", true},
+	} {
+		if tracked := test.tracked(group.rule, group.hostname); tracked != group.tracked {
+			t.Errorf("group of %s for %s tracked = %t, want %t", group.rule.RuleName, group.hostname, tracked, group.tracked)
+		}
+	}
+
+	// the long window still counts its old match
+	test.match(2*thresholdSweepInterval+time.Second, long, "r1", "f", []string{"b", "f"})
+}
+
+func TestThresholdFlush(t *testing.T) {
+	rule := confighandler.Rule{RuleName: "crc", Threshold: confighandler.Threshold{Count: 2, Window: time.Minute}}
+	test := newThresholdTest(t)
+
+	test.match(0, rule, "r1", "a", nil)
+	test.counter.Flush(func(incident lib.Incident) {
+		t.Errorf("Flush emitted %s, groups below their threshold never become incidents", incident.RawIncident)
+	})
+	test.match(time.Second, rule, "r1", "b", nil)
+}
@@ -16,7 +16,8 @@ def kill_process():
     result = {
         "success": True,
         "passed": True,
-        "result": "Process is killed" 
+        "result": "Process is killed",
+        "data": {"process": "gone"}
     }
     print(json.dumps(result))
     logging.warning("kill_process.py: Some stderr output")
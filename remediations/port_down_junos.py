@@ -0,0 +1,31 @@
+#!/usr/bin/env python3
+
+# Copyright (c) Facebook, Inc. and its affiliates.
+# All rights reserved.
+
+# This source code is licensed under the BSD-style license found in the
+# LICENSE file in the root directory of this source tree.
+
+import argparse
+import json
+import logging
+
+
+def port_down_junos():
+
+    # Parameters arrive as one --name=value argument each, unknown ones are ignored
+    parser = argparse.ArgumentParser()
+    parser.add_argument("--hostname", required=True)
+    parser.add_argument("--interface", required=True)
+    args, _ = parser.parse_known_args()
+
+    result = {
+        "success": True,
+        "passed": True,
+        "result": "port_down_junos.py worked on %s %s" % (args.hostname, args.interface)
+    }
+    print(json.dumps(result))
+    logging.warning("port_down_junos.py: Some stderr output")
+
+if __name__ == "__main__":
+    port_down_junos()